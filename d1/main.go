@@ -2,14 +2,60 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 )
 
+const (
+	// InputFilePath is the default path to the input for this puzzle
+	InputFilePath string = "./input.txt"
+	// MaxLineLength is the longest line the scanner accepts - bufio's default of 64 KiB is too short for generated inputs
+	MaxLineLength int = 64 * 1024 * 1024
+	// Target is the sum the expenses should add up to
+	Target int = 2020
+)
+
+// StreamPairSearch reads values one at a time and stops at the first one whose complement to the target has already been seen
+// Only the values seen so far are kept, in a hash set, so the input never needs to be held in memory or sorted
+// The returned line number is the line of the second value of the pair; false is returned if the input runs out first
+func StreamPairSearch(reader io.Reader, target int) (int, int, int, bool, error) {
+	seen := make(map[int]struct{})
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineLength)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		value, err := strconv.Atoi(line)
+		if err != nil {
+			return 0, 0, lineNumber, false, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		if _, ok := seen[target-value]; ok {
+			return target - value, value, lineNumber, true, nil
+		}
+		seen[value] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, lineNumber, false, fmt.Errorf("line %d: %v", lineNumber+1, err)
+	}
+	return 0, 0, lineNumber, false, nil
+}
+
 func main() {
+	path := flag.String("input", InputFilePath, "path to the expense report")
+	stream := flag.Bool("stream", false, "search for the P1 pair as values are read, without loading or sorting the report")
+	target := flag.Int("target", Target, "sum the expenses should add up to")
+	flag.Parse()
+
 	/*
 	 * Strategy:
 	 *   The naive method is to parse systematically through every pair until a hit is found - there's only one, anyway
@@ -21,12 +67,31 @@ func main() {
 	 */
 
 	// Reader
-	path := "./input.txt"
-	buf, err := os.Open(path)
+	buf, err := os.Open(*path)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer buf.Close()
+
+	// Streaming only answers the two-value problem, as the three-value problem needs every value anyway
+	if *stream {
+		low, high, lineNumber, found, err := StreamPairSearch(buf, *target)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !found {
+			log.Fatal(fmt.Sprintf("STREAM | No pair sums to %d in %d lines", *target, lineNumber))
+		}
+		if low > high {
+			low, high = high, low
+		}
+		log.Print(fmt.Sprintf("STREAM | Found on line: %d", lineNumber))
+		log.Print(fmt.Sprintf("P1: Low: %d | High: %d | Result: %d", low, high, low*high))
+		return
+	}
+
 	scanner := bufio.NewScanner(buf)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineLength)
 
 	// Gather input
 	var input []int
@@ -37,13 +102,17 @@ func main() {
 		}
 		input = append(input, int(inputInt))
 	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
 	sort.Ints(input)
 
 	// Now search for the two-value problem
 	low := 0
 	high := len(input) - 1
-	for input[low]+input[high] != 2020 {
-		if input[low]+input[high] < 2020 {
+	// A target other than 2020 may have no pair at all, so stop when the indexes collide
+	for low < high && input[low]+input[high] != *target {
+		if input[low]+input[high] < *target {
 			low++
 		} else {
 			high--
@@ -51,26 +120,35 @@ func main() {
 	}
 
 	// Out with it
-	log.Print(fmt.Sprintf("P1: Low: %d | High: %d | Result: %d", input[low], input[high], input[low]*input[high]))
+	if low < high {
+		log.Print(fmt.Sprintf("P1: Low: %d | High: %d | Result: %d", input[low], input[high], input[low]*input[high]))
+	} else {
+		log.Print(fmt.Sprintf("P1: No pair sums to %d", *target))
+	}
 
 	/*
 	 * The three-value problem is a little trickier.
 	 * Let's consider a "base" index. Subtracting the value for the base index from 2020 reveals the target sum for the low and high indexes.
 	 * However, it is no longer a guarantee that there will be a match. The search can stop when the low and high indexes collide.
 	 * If no match is found at that point, then the base index is raised by one, the low index is set to one above the base index, and the high index is reset.
-	 * This is repeated until a match is found.
+	 * This is repeated until a match is found, or until there are fewer than three values left above the base.
 	 */
 
 	base := 0
 	low = base + 1
 	high = len(input) - 1
-	for input[low]+input[high] != 2020-input[base] {
+	found := false
+	for base+2 < len(input) {
+		if input[low]+input[high] == *target-input[base] {
+			found = true
+			break
+		}
 		if high-low == 1 {
 			// At this point changing low up or high down will make the indexes collide, so it is time to reset
 			base++
 			low = base + 1
 			high = len(input) - 1
-		} else if input[low]+input[high] < 2020-input[base] {
+		} else if input[low]+input[high] < *target-input[base] {
 			low++
 		} else {
 			high--
@@ -78,5 +156,9 @@ func main() {
 	}
 
 	// Out with it
+	if !found {
+		log.Print(fmt.Sprintf("P2: No triple sums to %d", *target))
+		return
+	}
 	log.Print(fmt.Sprintf("P2: Base: %d | Low: %d | High: %d | Result: %d", input[base], input[low], input[high], input[base]*input[low]*input[high]))
 }
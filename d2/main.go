@@ -2,7 +2,11 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
@@ -10,96 +14,393 @@ import (
 	"strings"
 )
 
+const (
+	// InputFilePath is the default path to the input for this puzzle
+	InputFilePath string = "./input.txt"
+	// MaxLineLength is the longest line the scanner accepts - bufio's default of 64 KiB is too short for generated inputs
+	MaxLineLength int = 64 * 1024 * 1024
+)
+
+// PasswordRecord is a single entry of the password database: the policy and the password it applies to
+type PasswordRecord struct {
+	Min      int    `json:"min"`
+	Max      int    `json:"max"`
+	Char     string `json:"char"`
+	Password string `json:"password"`
+}
+
+// RecordFormat reads and writes password records, one record per line
+type RecordFormat interface {
+	Parse(line string) (PasswordRecord, error)
+	Format(record PasswordRecord) (string, error)
+}
+
+// AocFormat is the puzzle's own format, e.g. "1-3 a: abcde"
+type AocFormat struct{}
+
+// BraceFormat puts the range after the character, e.g. "a{1,3} abcde"
+type BraceFormat struct{}
+
+// JSONFormat writes one JSON object per line, e.g. {"min":1,"max":3,"char":"a","password":"abcde"}
+type JSONFormat struct{}
+
+// CustomFormat parses with a user-supplied regex and formats with a user-supplied template
+// The regex must have the named groups min, max, char and password; the template may use {min}, {max}, {char} and {password}
+type CustomFormat struct {
+	Pattern  *regexp.Regexp
+	Template string
+}
+
+var (
+	reAocRecord   = regexp.MustCompile(`^(\d+)-(\d+) (.): (.*)$`)
+	reBraceRecord = regexp.MustCompile(`^(.)\{(\d+),(\d+)\} (.*)$`)
+)
+
+// NewRecord builds a record from the raw text of its fields, checking that the policy makes sense
+func NewRecord(min, max, char, password string) (PasswordRecord, error) {
+	minValue, err := strconv.Atoi(min)
+	if err != nil {
+		return PasswordRecord{}, err
+	}
+	maxValue, err := strconv.Atoi(max)
+	if err != nil {
+		return PasswordRecord{}, err
+	}
+	record := PasswordRecord{minValue, maxValue, char, password}
+	return record, record.Check()
+}
+
+// Check reports whether a record can be written by every format without losing anything
+func (record PasswordRecord) Check() error {
+	if len([]rune(record.Char)) != 1 {
+		return fmt.Errorf("policy character %q should be exactly one character", record.Char)
+	}
+	if record.Min < 0 || record.Max < record.Min {
+		return fmt.Errorf("policy range %d-%d is not a valid range", record.Min, record.Max)
+	}
+	if strings.ContainsAny(record.Password, "\r\n") {
+		return fmt.Errorf("password %q spans more than one line", record.Password)
+	}
+	return nil
+}
+
+// Parse reads a record in the puzzle's format
+func (AocFormat) Parse(line string) (PasswordRecord, error) {
+	match := reAocRecord.FindStringSubmatch(line)
+	if match == nil {
+		return PasswordRecord{}, fmt.Errorf("%q does not look like \"min-max c: password\"", line)
+	}
+	return NewRecord(match[1], match[2], match[3], match[4])
+}
+
+// Format writes a record in the puzzle's format
+func (AocFormat) Format(record PasswordRecord) (string, error) {
+	if err := record.Check(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d %s: %s", record.Min, record.Max, record.Char, record.Password), nil
+}
+
+// Parse reads a record in the brace format
+func (BraceFormat) Parse(line string) (PasswordRecord, error) {
+	match := reBraceRecord.FindStringSubmatch(line)
+	if match == nil {
+		return PasswordRecord{}, fmt.Errorf("%q does not look like \"c{min,max} password\"", line)
+	}
+	return NewRecord(match[2], match[3], match[1], match[4])
+}
+
+// Format writes a record in the brace format
+func (BraceFormat) Format(record PasswordRecord) (string, error) {
+	if err := record.Check(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s{%d,%d} %s", record.Char, record.Min, record.Max, record.Password), nil
+}
+
+// Parse reads a record from a line of JSON
+func (JSONFormat) Parse(line string) (PasswordRecord, error) {
+	var record PasswordRecord
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&record); err != nil {
+		return PasswordRecord{}, err
+	}
+	// A line holds exactly one object, so anything after it would be lost on the way back out
+	var extra json.RawMessage
+	if err := decoder.Decode(&extra); err != io.EOF {
+		return PasswordRecord{}, fmt.Errorf("unexpected data after the record in %q", line)
+	}
+	return record, record.Check()
+}
+
+// Format writes a record as a line of JSON
+func (JSONFormat) Format(record PasswordRecord) (string, error) {
+	if err := record.Check(); err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(record)
+	return string(out), err
+}
+
+// NewCustomFormat compiles a custom format, making sure the regex captures every field
+// Either half may be left empty if the format is only going to be read or only going to be written
+func NewCustomFormat(pattern, template string) (CustomFormat, error) {
+	format := CustomFormat{Template: template}
+	if pattern == "" {
+		return format, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return format, err
+	}
+	for _, group := range []string{"min", "max", "char", "password"} {
+		if re.SubexpIndex(group) < 0 {
+			return format, fmt.Errorf("custom regex is missing the named group (?P<%s>...)", group)
+		}
+	}
+	format.Pattern = re
+	return format, nil
+}
+
+// Parse reads a record with the custom regex
+func (format CustomFormat) Parse(line string) (PasswordRecord, error) {
+	if format.Pattern == nil {
+		return PasswordRecord{}, errors.New("custom format has no regex to read with")
+	}
+	match := format.Pattern.FindStringSubmatch(line)
+	if match == nil {
+		return PasswordRecord{}, fmt.Errorf("%q does not match the custom regex", line)
+	}
+	group := func(name string) string {
+		return match[format.Pattern.SubexpIndex(name)]
+	}
+	return NewRecord(group("min"), group("max"), group("char"), group("password"))
+}
+
+// Format writes a record with the custom template
+func (format CustomFormat) Format(record PasswordRecord) (string, error) {
+	if format.Template == "" {
+		return "", errors.New("custom format has no template to write with")
+	}
+	if err := record.Check(); err != nil {
+		return "", err
+	}
+	replacer := strings.NewReplacer(
+		"{min}", strconv.Itoa(record.Min),
+		"{max}", strconv.Itoa(record.Max),
+		"{char}", record.Char,
+		"{password}", record.Password,
+	)
+	return replacer.Replace(format.Template), nil
+}
+
+// FormatByName looks up a format by the name used on the command line
+func FormatByName(name, pattern, template string) (RecordFormat, error) {
+	switch name {
+	case "aoc":
+		return AocFormat{}, nil
+	case "brace":
+		return BraceFormat{}, nil
+	case "json":
+		return JSONFormat{}, nil
+	case "custom":
+		return NewCustomFormat(pattern, template)
+	}
+	return nil, fmt.Errorf("unknown format %q; expected aoc, brace, json or custom", name)
+}
+
+// StreamRecords parses every non-empty line of the reader and hands each record to visit as soon as it is read
+// Nothing is kept between lines, so memory use does not grow with the size of the input
+// It stops at the first line that does not parse, the first error from visit, or a line longer than MaxLineLength
+func StreamRecords(reader io.Reader, format RecordFormat, visit func(lineNumber int, record PasswordRecord) error) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineLength)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record, err := format.Parse(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		if err := visit(lineNumber, record); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("line %d: %v", lineNumber+1, err)
+	}
+	return nil
+}
+
+// ReadRecords parses every non-empty line of the reader into memory
+func ReadRecords(reader io.Reader, format RecordFormat) ([]PasswordRecord, error) {
+	var records []PasswordRecord
+	err := StreamRecords(reader, format, func(lineNumber int, record PasswordRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ValidCount is the P1 policy: the character must appear between min and max times
+func ValidCount(record PasswordRecord) bool {
+	count := strings.Count(record.Password, record.Char)
+	return count >= record.Min && count <= record.Max
+}
+
+// ValidPosition is the P2 policy: the character must appear at exactly one of the positions min and max
+// Positions are 1-indexed, and a position past the end of the password is simply not a hit
+func ValidPosition(record PasswordRecord) bool {
+	posMin := record.Min - 1
+	posMax := record.Max - 1
+	hits := 0
+	chars := strings.Split(record.Password, "")
+	if posMin >= 0 && posMin < len(chars) {
+		if chars[posMin] == record.Char {
+			hits++
+		}
+	}
+	if posMax >= 0 && posMax < len(chars) {
+		if chars[posMax] == record.Char {
+			hits++
+		}
+	}
+	return hits == 1
+}
+
+// WriteRecords writes every record on its own line
+// Each written line is parsed back with the same format, so that a conversion that would lose information fails instead
+// A custom format without a regex cannot be read back, so it is written unchecked
+func WriteRecords(writer io.Writer, format RecordFormat, records []PasswordRecord) error {
+	custom, isCustom := format.(CustomFormat)
+	verify := !isCustom || custom.Pattern != nil
+	for i, record := range records {
+		line, err := format.Format(record)
+		if err != nil {
+			return fmt.Errorf("record %d: %v", i+1, err)
+		}
+		if strings.Contains(line, "\n") {
+			return fmt.Errorf("record %d: formatted record spans more than one line", i+1)
+		}
+		if verify {
+			back, err := format.Parse(line)
+			if err != nil {
+				return fmt.Errorf("record %d: does not read back: %v", i+1, err)
+			}
+			if back != record {
+				return fmt.Errorf("record %d: reads back as %+v instead of %+v", i+1, back, record)
+			}
+		}
+		if _, err := fmt.Fprintln(writer, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func main() {
+	inputFormat := flag.String("format", "aoc", "format of the input: aoc, brace, json or custom")
+	inputRegex := flag.String("regex", "", "regex with the named groups min, max, char and password, for reading the custom format")
+	convert := flag.String("convert", "", "write the input to stdout in this format instead of solving the puzzle")
+	template := flag.String("template", "", "template using {min}, {max}, {char} and {password}, for writing the custom format")
+	outputRegex := flag.String("out-regex", "", "regex for reading the custom output back, to check the conversion is lossless")
+	path := flag.String("input", InputFilePath, "path to the password database")
+	stream := flag.Bool("stream", false, "validate record by record without holding the database in memory")
+	progress := flag.Int("progress", 1000000, "when streaming, log the running counts every this many records; 0 disables")
+	flag.Parse()
+
 	// P1 requires regex to split the lines in data into two pieces:
 	//   1) The requirement
 	//   2) The password in storage
 	// This may be split on the string ": "
 	// The requirement string may then be further split into a count range and required char on the string " "
 	// The count range may then be split into a min and max on the string "-"
+	// All of that splitting now lives in the record formats, so that the same checks work on any of them.
 
 	// Reader
-	path := "./input.txt"
-	buf, err := os.Open(path)
+	buf, err := os.Open(*path)
 	if err != nil {
 		log.Fatal(err)
 	}
-	scanner := bufio.NewScanner(buf)
-
-	// Retrieve input
-	var input []string
-	for scanner.Scan() {
-		input = append(input, scanner.Text())
+	defer buf.Close()
+	reader, err := FormatByName(*inputFormat, *inputRegex, "")
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Split pw from requirement
-	var passwords []string
-	var requirements []string
-	rePassword := regexp.MustCompile(": ")
-	for i := 0; i < len(input); i++ {
-		split := rePassword.Split(input[i], -1)
-		requirements = append(requirements, split[0])
-		passwords = append(passwords, split[1])
+	// Streaming checks both policies as each record arrives, and only keeps the counts
+	if *stream {
+		if *convert != "" {
+			log.Fatal("Streaming only validates; run without -stream to convert")
+		}
+		records, validP1, validP2 := 0, 0, 0
+		err := StreamRecords(buf, reader, func(lineNumber int, record PasswordRecord) error {
+			records++
+			if ValidCount(record) {
+				validP1++
+			}
+			if ValidPosition(record) {
+				validP2++
+			}
+			if *progress > 0 && records%*progress == 0 {
+				log.Print(fmt.Sprintf("STREAM | Line: %d | Records: %d | P1 valid: %d | P2 valid: %d", lineNumber, records, validP1, validP2))
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Print(fmt.Sprintf("STREAM | Records: %d", records))
+		log.Print(fmt.Sprintf("P1 | Valid passwords: %d", validP1))
+		log.Print(fmt.Sprintf("P2 | Valid passwords: %d", validP2))
+		return
 	}
 
-	// Split count from char
-	var reqCount []string
-	var reqChar []string
-	reReq := regexp.MustCompile(" ")
-	for i := 0; i < len(requirements); i++ {
-		split := reReq.Split(requirements[i], -1)
-		reqCount = append(reqCount, split[0])
-		reqChar = append(reqChar, split[1])
+	// Retrieve input
+	records, err := ReadRecords(buf, reader)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Split min from max
-	var countMin []int
-	var countMax []int
-	reCount := regexp.MustCompile("-")
-	for i := 0; i < len(reqCount); i++ {
-		split := reCount.Split(reqCount[i], -1)
-		min, errMin := strconv.ParseInt(split[0], 10, 0)
-		if errMin != nil {
-			log.Fatal(errMin)
+	// Converting only rewrites the database in another format, so no policy is checked and there are no counts to log
+	if *convert != "" {
+		writer, err := FormatByName(*convert, *outputRegex, *template)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out := bufio.NewWriter(os.Stdout)
+		if err := WriteRecords(out, writer, records); err != nil {
+			log.Fatal(err)
 		}
-		max, errMax := strconv.ParseInt(split[1], 10, 0)
-		if errMax != nil {
-			log.Fatal(errMax)
+		if err := out.Flush(); err != nil {
+			log.Fatal(err)
 		}
-		countMin = append(countMin, int(min))
-		countMax = append(countMax, int(max))
+		log.Print(fmt.Sprintf("CONVERT | %s -> %s | Records: %d", *inputFormat, *convert, len(records)))
+		return
 	}
 
 	// Now count the number of OK pws
 	valid := 0
-	for i := 0; i < len(passwords); i++ {
-		count := strings.Count(passwords[i], reqChar[i])
-		if count >= countMin[i] && count <= countMax[i] {
+	for _, record := range records {
+		if ValidCount(record) {
 			valid++
 		}
 	}
 	log.Print(fmt.Sprintf("P1 | Valid passwords: %d", valid))
 
-	// With Puzzle 2, the countMin and countMax slices now indicate the positions of where characters should be searched in the password (not indexes)
-	// Before checking that the char exists at the given locations, the index (countMin[i] - 1 or countMax[i] - 1) should be checked that it's within the length of the pw string
+	// With Puzzle 2, the min and max now indicate the positions of where characters should be searched in the password (not indexes)
+	// Before checking that the char exists at the given locations, the index (min - 1 or max - 1) should be checked that it's within the length of the pw string
 	// Otherwise skip checking for that index
 	valid = 0
-	for i := 0; i < len(passwords); i++ {
-		posMin := countMin[i] - 1
-		posMax := countMax[i] - 1
-		hits := 0
-		chars := strings.Split(passwords[i], "")
-		if posMin < len(chars) {
-			if chars[posMin] == reqChar[i] {
-				hits++
-			}
-		}
-		if posMax < len(chars) {
-			if chars[posMax] == reqChar[i] {
-				hits++
-			}
-		}
-		if hits == 1 {
+	for _, record := range records {
+		if ValidPosition(record) {
 			valid++
 		}
 	}
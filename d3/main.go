@@ -2,34 +2,423 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 )
 
-func ouchieCounter(field []string, startRight, startDown, cadenceRight, cadenceDown int) int {
-	ouchies := 0
+// Slope is a toboggan cadence - the number of squares moved right and down on every step
+type Slope struct {
+	Right int
+	Down  int
+}
+
+// SlopeResult is the number of trees hit on a single slope from a single starting column
+type SlopeResult struct {
+	Start int
+	Slope Slope
+	Trees int
+}
+
+// CountTrees counts the trees hit on a slope, starting from the given column and row of the field
+// It is the quiet version of ouchieCounter, so it may be called as often as needed without flooding the log
+func CountTrees(field []string, startRight, startDown, cadenceRight, cadenceDown int) int {
+	trees := 0
 	positionRight := startRight
-	positionDown := startDown
-	for lineN, line := range field {
-		if lineN%cadenceDown == 0 {
-			rowSplit := strings.Split(line, "")
-			if positionRight >= len(rowSplit) {
-				positionRight %= len(rowSplit)
+	for positionDown := startDown; positionDown < len(field); positionDown += cadenceDown {
+		line := field[positionDown]
+		if line == "" {
+			continue
+		}
+		positionRight %= len(line)
+		if line[positionRight] == '#' {
+			trees++
+		}
+		positionRight += cadenceRight
+	}
+	return trees
+}
+
+// Square is a position on the field, where Column is not wrapped back onto the repeating pattern
+type Square struct {
+	Row    int
+	Column int
+}
+
+// SlopePath returns every square checked on a slope, in the same order and with the same start as CountTrees
+func SlopePath(field []string, startRight, startDown, cadenceRight, cadenceDown int) []Square {
+	var path []Square
+	positionRight := startRight
+	for positionDown := startDown; positionDown < len(field); positionDown += cadenceDown {
+		if field[positionDown] == "" {
+			continue
+		}
+		path = append(path, Square{positionDown, positionRight})
+		positionRight += cadenceRight
+	}
+	return path
+}
+
+// RenderPath overlays a path onto the field, marking open squares that were hit with O and trees that were hit with X
+// The pattern is repeated to the right for as many copies as the path needs, and the starting square is left unmarked, just like the example in the puzzle
+// Blank rows are drawn empty, as no path lands on them, but every other row has to be as wide as the first or the copies would not line up
+func RenderPath(field []string, path []Square) ([]string, error) {
+	width := 0
+	for r, line := range field {
+		if line == "" {
+			continue
+		}
+		if width == 0 {
+			width = len(line)
+		}
+		if len(line) != width {
+			return nil, fmt.Errorf("row %d is %d squares wide, but the pattern is %d wide", r+1, len(line), width)
+		}
+	}
+	if width == 0 {
+		return nil, errors.New("the field is empty")
+	}
+	// A path that steps left goes into copies of the pattern before the first, so shift everything right by whole copies
+	minColumn, maxColumn := 0, 0
+	for _, square := range path {
+		if square.Column < minColumn {
+			minColumn = square.Column
+		}
+		if square.Column > maxColumn {
+			maxColumn = square.Column
+		}
+	}
+	shift := (-minColumn + width - 1) / width * width
+	repeats := (maxColumn+shift)/width + 1
+
+	rows := make([][]byte, len(field))
+	for r, line := range field {
+		rows[r] = []byte(strings.Repeat(line, repeats))
+	}
+	for i, square := range path {
+		if i == 0 {
+			continue
+		}
+		column := square.Column + shift
+		if rows[square.Row][column] == '#' {
+			rows[square.Row][column] = 'X'
+		} else {
+			rows[square.Row][column] = 'O'
+		}
+	}
+
+	rendered := make([]string, len(rows))
+	for r, row := range rows {
+		rendered[r] = string(row)
+	}
+	return rendered, nil
+}
+
+// WritePathPNG saves a rendered field as a PNG image, drawing every square as a block of scale by scale pixels
+func WritePathPNG(rendered []string, path string, scale int) error {
+	palette := map[byte]color.RGBA{
+		'.': {0xf4, 0xf4, 0xf4, 0xff}, // Snow
+		'#': {0x1f, 0x6b, 0x2e, 0xff}, // Tree
+		'O': {0x3b, 0x82, 0xf6, 0xff}, // Open square hit
+		'X': {0xdc, 0x26, 0x26, 0xff}, // Tree hit
+	}
+	width := 0
+	for _, row := range rendered {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width*scale, len(rendered)*scale))
+	for r, row := range rendered {
+		for c := 0; c < len(row); c++ {
+			fill, ok := palette[row[c]]
+			if !ok {
+				return fmt.Errorf("unexpected square %q at row %d column %d", row[c], r, c)
 			}
-			if rowSplit[positionRight] == "#" {
-				ouchies++
+			for y := r * scale; y < (r+1)*scale; y++ {
+				for x := c * scale; x < (c+1)*scale; x++ {
+					img.SetRGBA(x, y, fill)
+				}
 			}
-			positionRight += cadenceRight
-			positionDown += cadenceDown
 		}
 	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(out, img); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Route is a path through the field together with the number of trees hit along it
+type Route struct {
+	Squares []Square
+	Trees   int
+}
+
+// FewestTreesRoute searches for the route from the top row to the bottom row that hits the fewest trees
+// Every move is one of the given steps, and the field wraps horizontally, so a square is only identified by its row and its column within the pattern
+// Every step goes down at least one row, so working through the rows in order settles each square before any step leaves it
+// The route's squares are unwrapped again afterwards so that RenderPath can draw it; false is returned if the bottom row cannot be reached
+// Unlike CountTrees, every row has to be there and as wide as the first, as a route may land on any square of any row
+func FewestTreesRoute(field []string, starts []int, steps []Slope) (Route, bool, error) {
+	if len(field) == 0 || field[0] == "" {
+		return Route{}, false, errors.New("the field is empty")
+	}
+	width := len(field[0])
+	for r, row := range field {
+		if row == "" {
+			return Route{}, false, fmt.Errorf("row %d is blank", r+1)
+		}
+		if len(row) != width {
+			return Route{}, false, fmt.Errorf("row %d is %d squares wide, but the pattern is %d wide", r+1, len(row), width)
+		}
+	}
+	for _, step := range steps {
+		if step.Down < 1 {
+			return Route{}, false, fmt.Errorf("step %v must go down at least one row", step)
+		}
+	}
+	for _, start := range starts {
+		if start < 0 || start >= width {
+			return Route{}, false, fmt.Errorf("starting column %d is outside the pattern width of %d", start, width)
+		}
+	}
+	cost := func(row, column int) int {
+		if field[row][column] == '#' {
+			return 1
+		}
+		return 0
+	}
+
+	// Distances and the step taken into each square, indexed by row and then column in the pattern
+	dist := make([][]int, len(field))
+	via := make([][]int, len(field))
+	for r := range field {
+		dist[r] = make([]int, width)
+		via[r] = make([]int, width)
+		for c := range dist[r] {
+			dist[r][c] = -1
+			via[r][c] = -1
+		}
+	}
+
+	for _, start := range starts {
+		dist[0][start] = cost(0, start)
+	}
+	for row := range field {
+		for column := 0; column < width; column++ {
+			if dist[row][column] < 0 {
+				continue
+			}
+			for i, step := range steps {
+				next := Square{row + step.Down, ((column+step.Right)%width + width) % width}
+				if next.Row >= len(field) {
+					continue
+				}
+				total := dist[row][column] + cost(next.Row, next.Column)
+				if dist[next.Row][next.Column] < 0 || total < dist[next.Row][next.Column] {
+					dist[next.Row][next.Column] = total
+					via[next.Row][next.Column] = i
+				}
+			}
+		}
+	}
+
+	// Pick the cheapest square in the bottom row
+	bottom := len(field) - 1
+	end := -1
+	for c := 0; c < width; c++ {
+		if dist[bottom][c] >= 0 && (end < 0 || dist[bottom][c] < dist[bottom][end]) {
+			end = c
+		}
+	}
+	if end < 0 {
+		return Route{}, false, nil
+	}
+
+	// Walk back to the top row, then replay the steps forward to unwrap the columns
+	var taken []Slope
+	square := Square{bottom, end}
+	for via[square.Row][square.Column] >= 0 {
+		step := steps[via[square.Row][square.Column]]
+		taken = append(taken, step)
+		square = Square{square.Row - step.Down, ((square.Column-step.Right)%width + width) % width}
+	}
+	squares := []Square{square}
+	for i := len(taken) - 1; i >= 0; i-- {
+		square = Square{square.Row + taken[i].Down, square.Column + taken[i].Right}
+		squares = append(squares, square)
+	}
+	return Route{squares, dist[bottom][end]}, true, nil
+}
+
+func ouchieCounter(field []string, startRight, startDown, cadenceRight, cadenceDown int) int {
+	ouchies := CountTrees(field, startRight, startDown, cadenceRight, cadenceDown)
 	log.Print(fmt.Sprintf("P2 | Cadence [R,D]: [%d,%d] | Ouchies: %d", cadenceRight, cadenceDown, ouchies))
 	return ouchies
 }
 
+// ExploreSlopes counts the trees for every slope up to maxRight and maxDown, from every given starting column
+// Right may be 0 (straight down) but down starts at 1, as a slope that never goes down never reaches the bottom
+// Results are ordered by start column, then down, then right
+func ExploreSlopes(field []string, starts []int, maxRight, maxDown int) []SlopeResult {
+	var results []SlopeResult
+	for _, start := range starts {
+		for down := 1; down <= maxDown; down++ {
+			for right := 0; right <= maxRight; right++ {
+				trees := CountTrees(field, start, 0, right, down)
+				results = append(results, SlopeResult{start, Slope{right, down}, trees})
+			}
+		}
+	}
+	return results
+}
+
+// ExtremeSlopes returns every result sharing the lowest tree count and every result sharing the highest tree count
+func ExtremeSlopes(results []SlopeResult) ([]SlopeResult, []SlopeResult) {
+	var lowest, highest []SlopeResult
+	for _, result := range results {
+		if len(lowest) == 0 || result.Trees < lowest[0].Trees {
+			lowest = []SlopeResult{result}
+		} else if result.Trees == lowest[0].Trees {
+			lowest = append(lowest, result)
+		}
+		if len(highest) == 0 || result.Trees > highest[0].Trees {
+			highest = []SlopeResult{result}
+		} else if result.Trees == highest[0].Trees {
+			highest = append(highest, result)
+		}
+	}
+	return lowest, highest
+}
+
+// SlopeProduct multiplies together the trees hit on every given slope from a single starting column
+func SlopeProduct(field []string, start int, slopes []Slope) int {
+	product := 1
+	for _, slope := range slopes {
+		product *= CountTrees(field, start, 0, slope.Right, slope.Down)
+	}
+	return product
+}
+
+// ParseSlopes reads a list of slopes written as R,D pairs separated by semicolons, e.g. "1,1;3,1;1,2"
+// A toboggan slope only ever goes right, so R must be at least 0
+func ParseSlopes(spec string) ([]Slope, error) {
+	slopes, err := ParseSteps(spec)
+	if err != nil {
+		return nil, err
+	}
+	for _, slope := range slopes {
+		if slope.Right < 0 {
+			return nil, fmt.Errorf("slope %d,%d must have R >= 0", slope.Right, slope.Down)
+		}
+	}
+	return slopes, nil
+}
+
+// ParseSteps reads R,D pairs the same way as ParseSlopes, but R may be negative to step left
+func ParseSteps(spec string) ([]Slope, error) {
+	var slopes []Slope
+	for _, pair := range strings.Split(spec, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		split := strings.Split(pair, ",")
+		if len(split) != 2 {
+			return nil, fmt.Errorf("slope %q is not an R,D pair", pair)
+		}
+		right, err := strconv.Atoi(strings.TrimSpace(split[0]))
+		if err != nil {
+			return nil, fmt.Errorf("slope %q: %v", pair, err)
+		}
+		down, err := strconv.Atoi(strings.TrimSpace(split[1]))
+		if err != nil {
+			return nil, fmt.Errorf("slope %q: %v", pair, err)
+		}
+		if down < 1 {
+			return nil, fmt.Errorf("slope %q must have D >= 1", pair)
+		}
+		slopes = append(slopes, Slope{right, down})
+	}
+	if len(slopes) == 0 {
+		return nil, errors.New("no slopes given")
+	}
+	return slopes, nil
+}
+
+// PrintSlopeMatrix prints the tree counts for one starting column as a grid, with a row per down and a column per right
+func PrintSlopeMatrix(results []SlopeResult, start, maxRight, maxDown int) {
+	log.Println("Start column", start, "| Trees by [R,D]:")
+	header := []string{"D\\R"}
+	for right := 0; right <= maxRight; right++ {
+		header = append(header, strconv.Itoa(right))
+	}
+	log.Println(strings.Join(header, "\t"))
+	for down := 1; down <= maxDown; down++ {
+		row := []string{strconv.Itoa(down)}
+		for _, result := range results {
+			if result.Start == start && result.Slope.Down == down {
+				row = append(row, strconv.Itoa(result.Trees))
+			}
+		}
+		log.Println(strings.Join(row, "\t"))
+	}
+}
+
+// Explore runs the slope search over the field and logs the matrix, the extremes, and the product of the given slopes
+func Explore(field []string, start, maxRight, maxDown int, slopes []Slope) {
+	// A start of -1 means every column in the pattern
+	var starts []int
+	if start < 0 {
+		for column := 0; column < len(field[0]); column++ {
+			starts = append(starts, column)
+		}
+	} else {
+		starts = append(starts, start)
+	}
+
+	results := ExploreSlopes(field, starts, maxRight, maxDown)
+	for _, s := range starts {
+		PrintSlopeMatrix(results, s, maxRight, maxDown)
+	}
+
+	lowest, highest := ExtremeSlopes(results)
+	for _, result := range lowest {
+		log.Print(fmt.Sprintf("EXPLORE | Fewest trees | Start: %d | Cadence [R,D]: [%d,%d] | Ouchies: %d", result.Start, result.Slope.Right, result.Slope.Down, result.Trees))
+	}
+	for _, result := range highest {
+		log.Print(fmt.Sprintf("EXPLORE | Most trees | Start: %d | Cadence [R,D]: [%d,%d] | Ouchies: %d", result.Start, result.Slope.Right, result.Slope.Down, result.Trees))
+	}
+	for _, s := range starts {
+		log.Print(fmt.Sprintf("EXPLORE | Start: %d | Slopes: %v | Ouchie Product: %d", s, slopes, SlopeProduct(field, s, slopes)))
+	}
+}
+
 func main() {
+	explore := flag.Bool("explore", false, "search every slope up to -max-right and -max-down instead of solving the puzzle")
+	maxRight := flag.Int("max-right", 7, "largest right cadence to search when exploring")
+	maxDown := flag.Int("max-down", 2, "largest down cadence to search when exploring")
+	start := flag.Int("start", 0, "starting column when exploring; -1 searches every column")
+	slopeSpec := flag.String("slopes", "1,1;3,1;5,1;7,1;1,2", "slopes to multiply together when exploring, as R,D pairs separated by semicolons")
+	renderSpec := flag.String("render", "", "print the field with the path of this R,D slope overlaid, starting from -start")
+	avoidSpec := flag.String("avoid", "", "find the route to the bottom row hitting the fewest trees, moving by these R,D steps separated by semicolons (R may be negative to step left)")
+	pngPath := flag.String("png", "", "also save the rendered path to this PNG file")
+	pngScale := flag.Int("png-scale", 4, "size in pixels of each square in the PNG")
+	flag.Parse()
+
 	// P1: Traversal through the field can be simply performed by iteration and mod math.
 	// This is because the tree pattern repeats infinitely to the right, which is the direction of traversal anyway.
 	// As the iteration over lines proceeds, if the next index for pulling up the field value is beyond the bounds of the upcoming line,
@@ -55,6 +444,109 @@ func main() {
 		input = append(input, scanner.Text())
 	}
 
+	// Every other mode needs a pattern to place the start column in, and only -1 stands for every column
+	if *explore || *renderSpec != "" || *avoidSpec != "" {
+		if len(input) == 0 || input[0] == "" {
+			log.Fatal("The field is empty")
+		}
+		if *start < -1 || *start >= len(input[0]) {
+			log.Fatal("Starting column ", *start, " is outside the pattern width of ", len(input[0]))
+		}
+	}
+
+	// The explorer counts every slope up to the limits, which already covers the two fixed slopes of P1 and P2
+	if *explore {
+		if *maxRight < 0 || *maxDown < 1 {
+			log.Fatal("Exploration needs -max-right >= 0 and -max-down >= 1")
+		}
+		slopes, err := ParseSlopes(*slopeSpec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		Explore(input, *start, *maxRight, *maxDown, slopes)
+		return
+	}
+
+	// Rendering also replaces the puzzle answers
+	if *renderSpec != "" {
+		slopes, err := ParseSlopes(*renderSpec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(slopes) != 1 {
+			log.Fatal("Rendering needs exactly one slope, got ", len(slopes))
+		}
+		if *start < 0 {
+			log.Fatal("Rendering needs a single starting column, not ", *start)
+		}
+		slope := slopes[0]
+		path := SlopePath(input, *start, 0, slope.Right, slope.Down)
+		rendered, err := RenderPath(input, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, row := range rendered {
+			log.Println(row)
+		}
+		log.Print(fmt.Sprintf("RENDER | Start: %d | Cadence [R,D]: [%d,%d] | Ouchies: %d", *start, slope.Right, slope.Down, CountTrees(input, *start, 0, slope.Right, slope.Down)))
+		if *pngPath != "" {
+			if *pngScale < 1 {
+				log.Fatal("PNG scale must be at least 1")
+			}
+			if err := WritePathPNG(rendered, *pngPath, *pngScale); err != nil {
+				log.Fatal(err)
+			}
+			log.Println("RENDER | Saved PNG to", *pngPath)
+		}
+		return
+	}
+
+	// So does pathfinding
+	if *avoidSpec != "" {
+		steps, err := ParseSteps(*avoidSpec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var starts []int
+		if *start < 0 {
+			for column := 0; column < len(input[0]); column++ {
+				starts = append(starts, column)
+			}
+		} else {
+			starts = append(starts, *start)
+		}
+		route, ok, err := FewestTreesRoute(input, starts, steps)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			log.Fatal("The bottom row cannot be reached with steps ", *avoidSpec)
+		}
+		rendered, err := RenderPath(input, route.Squares)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, row := range rendered {
+			log.Println(row)
+		}
+		var moves []string
+		for i := 1; i < len(route.Squares); i++ {
+			moves = append(moves, fmt.Sprintf("[%d,%d]", route.Squares[i].Column-route.Squares[i-1].Column, route.Squares[i].Row-route.Squares[i-1].Row))
+		}
+		log.Print(fmt.Sprintf("AVOID | Start: %d | Moves [R,D]: %s", route.Squares[0].Column, strings.Join(moves, " ")))
+		log.Print(fmt.Sprintf("AVOID | Steps: %d | Ouchies: %d", len(moves), route.Trees))
+		if *pngPath != "" {
+			if *pngScale < 1 {
+				log.Fatal("PNG scale must be at least 1")
+			}
+			if err := WritePathPNG(rendered, *pngPath, *pngScale); err != nil {
+				log.Fatal(err)
+			}
+			log.Println("AVOID | Saved PNG to", *pngPath)
+		}
+		return
+	}
+
 	// Time to slam into trees
 	ouchies := 0
 	positionRight := 0
@@ -2,14 +2,24 @@ package main
 
 import (
 	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+const (
+	// SchemaFilePath is the default path to the validation schema for this puzzle
+	SchemaFilePath string = "./schema.json"
+)
+
 // Passport contains all possible passport fields as strings
 type Passport struct {
 	byr string
@@ -22,36 +32,882 @@ type Passport struct {
 	cid string
 }
 
-func resetPassport(passport *Passport) {
-	passport.byr = ""
-	passport.iyr = ""
-	passport.eyr = ""
-	passport.hgt = ""
-	passport.hcl = ""
-	passport.ecl = ""
-	passport.pid = ""
-	passport.cid = ""
+// NewPassport fills a Passport from parsed fields; fields it has no room for are left out
+func NewPassport(fields map[string]string) Passport {
+	return Passport{
+		byr: fields["byr"],
+		iyr: fields["iyr"],
+		eyr: fields["eyr"],
+		hgt: fields["hgt"],
+		hcl: fields["hcl"],
+		ecl: fields["ecl"],
+		pid: fields["pid"],
+		cid: fields["cid"],
+	}
+}
+
+// Fields returns the passport's fields keyed by name, leaving out any field that was not given
+func (passport Passport) Fields() map[string]string {
+	fields := make(map[string]string)
+	for name, value := range map[string]string{
+		"byr": passport.byr,
+		"iyr": passport.iyr,
+		"eyr": passport.eyr,
+		"hgt": passport.hgt,
+		"hcl": passport.hcl,
+		"ecl": passport.ecl,
+		"pid": passport.pid,
+		"cid": passport.cid,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// Batch writes the passport back in the puzzle's batch format, on one line with its fields in the usual order
+func (passport Passport) Batch() string {
+	var tokens []string
+	for _, field := range [][2]string{
+		{"byr", passport.byr},
+		{"iyr", passport.iyr},
+		{"eyr", passport.eyr},
+		{"hgt", passport.hgt},
+		{"hcl", passport.hcl},
+		{"ecl", passport.ecl},
+		{"pid", passport.pid},
+		{"cid", passport.cid},
+	} {
+		if field[1] != "" {
+			tokens = append(tokens, field[0]+":"+field[1])
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+// Height is a height value along with its unit, cm or in
+type Height struct {
+	Value int    `json:"value"`
+	Unit  string `json:"unit"`
+}
+
+func (height Height) String() string {
+	return strconv.Itoa(height.Value) + height.Unit
+}
+
+// HexColour is a 24-bit RGB colour, written as # followed by six hex digits
+type HexColour uint32
+
+func (colour HexColour) String() string {
+	return fmt.Sprintf("#%06x", uint32(colour))
+}
+
+// MarshalJSON writes the colour the same way it is written in a passport, rather than as a plain number
+func (colour HexColour) MarshalJSON() ([]byte, error) {
+	return json.Marshal(colour.String())
+}
+
+// EyeColour is one of the eye colours a passport may list
+type EyeColour string
+
+// The eye colours
+const (
+	EyeAmber EyeColour = "amb"
+	EyeBlue  EyeColour = "blu"
+	EyeBrown EyeColour = "brn"
+	EyeGrey  EyeColour = "gry"
+	EyeGreen EyeColour = "grn"
+	EyeHazel EyeColour = "hzl"
+	EyeOther EyeColour = "oth"
+)
+
+// EyeColours lists every eye colour, in the order the puzzle lists them
+var EyeColours = []EyeColour{EyeAmber, EyeBlue, EyeBrown, EyeGrey, EyeGreen, EyeHazel, EyeOther}
+
+// NormalizedPassport is a passport with typed values, for exporting to other tools
+// A value that cannot be typed is left out, and the reason why is added to Errors
+type NormalizedPassport struct {
+	Line           int        `json:"line"`
+	BirthYear      *int       `json:"birthYear,omitempty"`
+	IssueYear      *int       `json:"issueYear,omitempty"`
+	ExpirationYear *int       `json:"expirationYear,omitempty"`
+	Height         *Height    `json:"height,omitempty"`
+	HairColour     *HexColour `json:"hairColour,omitempty"`
+	EyeColour      EyeColour  `json:"eyeColour,omitempty"`
+	PassportID     string     `json:"passportId,omitempty"`
+	CountryID      string     `json:"countryId,omitempty"`
+	Errors         []string   `json:"errors,omitempty"`
+}
+
+var (
+	reYear     = regexp.MustCompile(`^\d{4}$`)
+	reHeight   = regexp.MustCompile(`^(\d+)(cm|in)$`)
+	reHex      = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	rePassport = regexp.MustCompile(`^\d+$`)
+)
+
+// Normalize types the passport's values
+// This only checks that a value has the right shape for its type - whether it is in range is still up to the schema
+func (passport Passport) Normalize(line int) NormalizedPassport {
+	normal := NormalizedPassport{Line: line, PassportID: passport.pid, CountryID: passport.cid}
+	year := func(field, value string) *int {
+		if value == "" {
+			return nil
+		}
+		if !reYear.MatchString(value) {
+			normal.Errors = append(normal.Errors, fmt.Sprintf("%s %s is not a four digit year", field, value))
+			return nil
+		}
+		number, _ := strconv.Atoi(value)
+		return &number
+	}
+	normal.BirthYear = year("byr", passport.byr)
+	normal.IssueYear = year("iyr", passport.iyr)
+	normal.ExpirationYear = year("eyr", passport.eyr)
+
+	if passport.hgt != "" {
+		if match := reHeight.FindStringSubmatch(passport.hgt); match != nil {
+			value, _ := strconv.Atoi(match[1])
+			normal.Height = &Height{value, match[2]}
+		} else {
+			normal.Errors = append(normal.Errors, fmt.Sprintf("hgt %s is not a number followed by cm or in", passport.hgt))
+		}
+	}
+	if passport.hcl != "" {
+		if reHex.MatchString(passport.hcl) {
+			value, _ := strconv.ParseUint(passport.hcl[1:], 16, 32)
+			colour := HexColour(value)
+			normal.HairColour = &colour
+		} else {
+			normal.Errors = append(normal.Errors, fmt.Sprintf("hcl %s is not a hex colour", passport.hcl))
+		}
+	}
+	if passport.ecl != "" {
+		for _, colour := range EyeColours {
+			if passport.ecl == string(colour) {
+				normal.EyeColour = colour
+			}
+		}
+		if normal.EyeColour == "" {
+			normal.Errors = append(normal.Errors, fmt.Sprintf("ecl %s is not an eye colour", passport.ecl))
+		}
+	}
+	if passport.pid != "" && !rePassport.MatchString(passport.pid) {
+		normal.Errors = append(normal.Errors, fmt.Sprintf("pid %s is not all digits", passport.pid))
+		normal.PassportID = ""
+	}
+	return normal
+}
+
+// WriteNormalizedJSON writes the passports as a single indented JSON array
+func WriteNormalizedJSON(writer io.Writer, passports []NormalizedPassport) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if passports == nil {
+		passports = []NormalizedPassport{}
+	}
+	return encoder.Encode(passports)
+}
+
+// WriteNormalizedCSV writes the passports as CSV with a header row; heights are split into a value and a unit column
+func WriteNormalizedCSV(writer io.Writer, passports []NormalizedPassport) error {
+	out := csv.NewWriter(writer)
+	if err := out.Write([]string{"line", "byr", "iyr", "eyr", "hgt_value", "hgt_unit", "hcl", "ecl", "pid", "cid", "errors"}); err != nil {
+		return err
+	}
+	optional := func(value *int) string {
+		if value == nil {
+			return ""
+		}
+		return strconv.Itoa(*value)
+	}
+	for _, passport := range passports {
+		row := []string{strconv.Itoa(passport.Line), optional(passport.BirthYear), optional(passport.IssueYear), optional(passport.ExpirationYear), "", "", "", string(passport.EyeColour), passport.PassportID, passport.CountryID, strings.Join(passport.Errors, "; ")}
+		if passport.Height != nil {
+			row[4] = strconv.Itoa(passport.Height.Value)
+			row[5] = passport.Height.Unit
+		}
+		if passport.HairColour != nil {
+			row[6] = passport.HairColour.String()
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteBatch writes the passports back in the puzzle's batch format, one passport per line with an empty line between them
+// The output is parsed back before returning, so a passport that would not survive the trip is an error rather than a silent change
+// A record with only unknown keys becomes a passport with no fields, which has no way to be written as a batch, so it is left out
+// It returns how many passports were written
+func WriteBatch(writer io.Writer, passports []Passport) (int, error) {
+	var lines []string
+	var written []Passport
+	for _, passport := range passports {
+		if passport == (Passport{}) {
+			continue
+		}
+		lines = append(lines, passport.Batch())
+		written = append(written, passport)
+	}
+	passports = written
+	text := strings.Join(lines, "\n\n") + "\n"
+	records, err := ParseRecords(strings.NewReader(text), nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(records) != len(passports) {
+		return 0, fmt.Errorf("wrote %d passports but read back %d", len(passports), len(records))
+	}
+	for i, record := range records {
+		if NewPassport(record.Fields) != passports[i] {
+			return 0, fmt.Errorf("passport %d reads back as %q instead of %q", i+1, NewPassport(record.Fields).Batch(), passports[i].Batch())
+		}
+	}
+	if _, err := io.WriteString(writer, text); err != nil {
+		return 0, err
+	}
+	return len(passports), nil
+}
+
+// Position is a place in the input file, counting lines and columns from 1
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// BatchLine is one non-empty line of a batch, along with where it came from
+type BatchLine struct {
+	Position
+	Text string
+}
+
+// Batch is a run of non-empty lines, which makes up a single passport
+type Batch struct {
+	Start Position
+	Lines []BatchLine
+}
+
+// ReadBatches splits the input into batches separated by one or more empty lines
+// The last batch is kept whether or not the input ends with an empty line
+func ReadBatches(reader io.Reader) ([]Batch, error) {
+	var batches []Batch
+	var current Batch
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(current.Lines) > 0 {
+				batches = append(batches, current)
+			}
+			current = Batch{}
+			continue
+		}
+		if len(current.Lines) == 0 {
+			current.Start = Position{lineNumber, 1}
+		}
+		current.Lines = append(current.Lines, BatchLine{Position{lineNumber, 1}, line})
+	}
+	if len(current.Lines) > 0 {
+		batches = append(batches, current)
+	}
+	return batches, scanner.Err()
+}
+
+// The kinds of RecordIssue
+const (
+	IssueMalformed string = "malformed"
+	IssueEmpty     string = "empty value"
+	IssueUnknown   string = "unknown key"
+	IssueDuplicate string = "duplicate key"
+)
+
+// RecordIssue is something wrong with how a record was written, as opposed to a value failing validation
+type RecordIssue struct {
+	Position
+	Key     string `json:"key,omitempty"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// Record is a batch of whitespace-separated key:value pairs
+// Where holds the position of every key in Fields, and Issues anything that was skipped while reading it
+type Record struct {
+	Start  Position
+	Fields map[string]string
+	Where  map[string]Position
+	Issues []RecordIssue
+}
+
+// ParseRecord reads the key:value pairs of a batch
+// Tokens without a colon and keys without values are skipped, and for a repeated key the first value wins
+// If known is not nil, keys outside of it are still kept but are flagged as unknown
+func ParseRecord(batch Batch, known map[string]struct{}) Record {
+	record := Record{Start: batch.Start, Fields: make(map[string]string), Where: make(map[string]Position)}
+	for _, line := range batch.Lines {
+		column := 0
+		for column < len(line.Text) {
+			// Skip to the next token, then read up to the whitespace after it
+			for column < len(line.Text) && (line.Text[column] == ' ' || line.Text[column] == '\t') {
+				column++
+			}
+			tokenStart := column
+			for column < len(line.Text) && line.Text[column] != ' ' && line.Text[column] != '\t' {
+				column++
+			}
+			if tokenStart == column {
+				break
+			}
+			token := line.Text[tokenStart:column]
+			position := Position{line.Line, tokenStart + 1}
+
+			split := strings.SplitN(token, ":", 2)
+			if len(split) != 2 || split[0] == "" {
+				record.Issues = append(record.Issues, RecordIssue{position, "", IssueMalformed, fmt.Sprintf("%q is not key:value", token)})
+				continue
+			}
+			key, value := split[0], split[1]
+			if value == "" {
+				record.Issues = append(record.Issues, RecordIssue{position, key, IssueEmpty, fmt.Sprintf("%s has no value", key)})
+				continue
+			}
+			if first, ok := record.Where[key]; ok {
+				record.Issues = append(record.Issues, RecordIssue{position, key, IssueDuplicate, fmt.Sprintf("%s:%s repeats %s first given at line %d column %d", key, value, key, first.Line, first.Column)})
+				continue
+			}
+			if _, ok := known[key]; known != nil && !ok {
+				record.Issues = append(record.Issues, RecordIssue{position, key, IssueUnknown, fmt.Sprintf("%s is not a known field", key)})
+			}
+			record.Fields[key] = value
+			record.Where[key] = position
+		}
+	}
+	return record
+}
+
+// ParseRecords reads every batch of the input as a record
+func ParseRecords(reader io.Reader, known map[string]struct{}) ([]Record, error) {
+	batches, err := ReadBatches(reader)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, len(batches))
+	for i, batch := range batches {
+		records[i] = ParseRecord(batch, known)
+	}
+	return records, nil
+}
+
+// Range is an inclusive range of integers; either end may be left out to leave it open
+type Range struct {
+	Min *int `json:"min,omitempty"`
+	Max *int `json:"max,omitempty"`
+}
+
+// FieldRule is the schema for a single passport field - every check that is set has to pass
+// Units is for values like hgt, where the number comes first and the unit after it decides which range applies
+type FieldRule struct {
+	Name     string           `json:"name"`
+	Required bool             `json:"required"`
+	Pattern  string           `json:"pattern,omitempty"`
+	Range                     // min and max sit directly on the field in the schema file
+	Units    map[string]Range `json:"units,omitempty"`
+	Enum     []string         `json:"enum,omitempty"`
+	re       *regexp.Regexp
+}
+
+// Schema is the complete set of passport validation rules, in the order they are listed in the schema file
+// Fields are checked one at a time; Rules are checked afterwards and may span several fields
+type Schema struct {
+	Fields []FieldRule `json:"fields"`
+	Rules  []CrossRule `json:"rules,omitempty"`
+}
+
+// Condition holds when Field is given and, if In is set, its value is one of In
+type Condition struct {
+	Field string   `json:"field"`
+	In    []string `json:"in,omitempty"`
+}
+
+// Comparison compares two fields as integers: Left Op Right + Offset, e.g. iyr >= byr + 18
+type Comparison struct {
+	Left   string `json:"left"`
+	Op     string `json:"op"`
+	Right  string `json:"right"`
+	Offset int    `json:"offset,omitempty"`
+}
+
+// CrossRule is a rule spanning several fields
+// If When is set, the rule only applies to passports meeting the condition
+// Require lists fields that must then be given, and Compare is a comparison that must then hold
+type CrossRule struct {
+	Name    string      `json:"name"`
+	When    *Condition  `json:"when,omitempty"`
+	Require []string    `json:"require,omitempty"`
+	Compare *Comparison `json:"compare,omitempty"`
+}
+
+// RuleError describes a passport breaking a cross-field rule, naming every field the rule involves
+type RuleError struct {
+	Rule   string   `json:"rule"`
+	Fields []string `json:"fields"`
+	Reason string   `json:"reason"`
+}
+
+func (e RuleError) Error() string {
+	return e.Reason
+}
+
+// FieldError describes why one field of a passport failed validation
+// Kind is the same for every failure of the same check, so failures can be tallied regardless of the value
+type FieldError struct {
+	Field   string `json:"field"`
+	Value   string `json:"value,omitempty"`
+	Missing bool   `json:"-"`
+	Kind    string `json:"kind"`
+	Reason  string `json:"reason"`
+}
+
+// The kinds of FieldError
+const (
+	KindMissing     string = "missing"
+	KindPattern     string = "pattern"
+	KindNotNumber   string = "not a number"
+	KindTooLow      string = "too low"
+	KindTooHigh     string = "too high"
+	KindNoUnit      string = "no unit"
+	KindUnknownUnit string = "unknown unit"
+	KindNotInEnum   string = "not in enum"
+)
+
+func (e FieldError) Error() string {
+	return e.Reason
+}
+
+// LoadSchema reads a validation schema from a JSON file and compiles its patterns
+// Only JSON is read - the days in this repository stick to the standard library, which has no YAML parser
+func LoadSchema(path string) (Schema, error) {
+	var schema Schema
+	buf, err := os.Open(path)
+	if err != nil {
+		return schema, err
+	}
+	defer buf.Close()
+	decoder := json.NewDecoder(buf)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&schema); err != nil {
+		return schema, fmt.Errorf("%s: %v", path, err)
+	}
+	seen := make(map[string]struct{})
+	for i := range schema.Fields {
+		rule := &schema.Fields[i]
+		if rule.Name == "" {
+			return schema, fmt.Errorf("%s: field %d has no name", path, i+1)
+		}
+		if _, ok := seen[rule.Name]; ok {
+			return schema, fmt.Errorf("%s: field %s is listed twice", path, rule.Name)
+		}
+		seen[rule.Name] = struct{}{}
+		if rule.Pattern != "" {
+			rule.re, err = regexp.Compile(rule.Pattern)
+			if err != nil {
+				return schema, fmt.Errorf("%s: field %s: %v", path, rule.Name, err)
+			}
+		}
+		if len(rule.Units) > 0 && (rule.Min != nil || rule.Max != nil) {
+			return schema, fmt.Errorf("%s: field %s has both a plain range and unit ranges", path, rule.Name)
+		}
+	}
+	for i, rule := range schema.Rules {
+		if rule.Name == "" {
+			return schema, fmt.Errorf("%s: rule %d has no name", path, i+1)
+		}
+		if len(rule.Require) == 0 && rule.Compare == nil {
+			return schema, fmt.Errorf("%s: rule %s neither requires fields nor compares them", path, rule.Name)
+		}
+		if rule.When != nil && rule.When.Field == "" {
+			return schema, fmt.Errorf("%s: rule %s has a condition without a field", path, rule.Name)
+		}
+		if rule.Compare != nil {
+			if rule.Compare.Left == "" || rule.Compare.Right == "" {
+				return schema, fmt.Errorf("%s: rule %s compares without naming both fields", path, rule.Name)
+			}
+			if _, ok := comparisons[rule.Compare.Op]; !ok {
+				return schema, fmt.Errorf("%s: rule %s has unknown comparison %q", path, rule.Name, rule.Compare.Op)
+			}
+		}
+	}
+	return schema, nil
+}
+
+// comparisons are the operators a Comparison may use
+var comparisons = map[string]func(a, b int) bool{
+	"<":  func(a, b int) bool { return a < b },
+	"<=": func(a, b int) bool { return a <= b },
+	">":  func(a, b int) bool { return a > b },
+	">=": func(a, b int) bool { return a >= b },
+	"==": func(a, b int) bool { return a == b },
+	"!=": func(a, b int) bool { return a != b },
+}
+
+// Fields lists every field the rule looks at, condition first, without repeats
+func (rule CrossRule) Fields() []string {
+	var fields []string
+	add := func(field string) {
+		for _, f := range fields {
+			if f == field {
+				return
+			}
+		}
+		fields = append(fields, field)
+	}
+	if rule.When != nil {
+		add(rule.When.Field)
+	}
+	for _, field := range rule.Require {
+		add(field)
+	}
+	if rule.Compare != nil {
+		add(rule.Compare.Left)
+		add(rule.Compare.Right)
+	}
+	return fields
+}
+
+// Holds reports whether the condition is met by the passport's fields
+func (condition Condition) Holds(fields map[string]string) bool {
+	value, ok := fields[condition.Field]
+	if !ok {
+		return false
+	}
+	if len(condition.In) == 0 {
+		return true
+	}
+	for _, allowed := range condition.In {
+		if value == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// String writes the condition the way it reads in a reason, e.g. "ecl is oth"
+func (condition Condition) String() string {
+	if len(condition.In) == 0 {
+		return condition.Field + " is given"
+	}
+	return condition.Field + " is " + strings.Join(condition.In, " or ")
+}
+
+// Check returns every way the passport breaks the rule
+// A comparison between fields that are missing or are not numbers is skipped, as those fields already fail on their own
+func (rule CrossRule) Check(fields map[string]string) []RuleError {
+	if rule.When != nil && !rule.When.Holds(fields) {
+		return nil
+	}
+	var failures []RuleError
+	for _, field := range rule.Require {
+		if _, ok := fields[field]; !ok {
+			reason := field + " is required"
+			if rule.When != nil {
+				reason += " when " + rule.When.String()
+			}
+			failures = append(failures, RuleError{rule.Name, rule.Fields(), reason})
+		}
+	}
+	if compare := rule.Compare; compare != nil {
+		left, errLeft := strconv.Atoi(fields[compare.Left])
+		right, errRight := strconv.Atoi(fields[compare.Right])
+		if errLeft == nil && errRight == nil && !comparisons[compare.Op](left, right+compare.Offset) {
+			expected := fmt.Sprintf("%s %s %s", compare.Left, compare.Op, compare.Right)
+			if compare.Offset > 0 {
+				expected += fmt.Sprintf(" + %d", compare.Offset)
+			} else if compare.Offset < 0 {
+				expected += fmt.Sprintf(" - %d", -compare.Offset)
+			}
+			reason := fmt.Sprintf("%s %d, %s %d breaks %s", compare.Left, left, compare.Right, right, expected)
+			if rule.When != nil {
+				reason += " when " + rule.When.String()
+			}
+			failures = append(failures, RuleError{rule.Name, rule.Fields(), reason})
+		}
+	}
+	return failures
+}
+
+// check returns nil if the number is within the range, or a FieldError saying which end it is past
+func (r Range) check(field, value string, number int) *FieldError {
+	if r.Min != nil && number < *r.Min {
+		return &FieldError{Field: field, Value: value, Kind: KindTooLow, Reason: fmt.Sprintf("%s %s < %d", field, value, *r.Min)}
+	}
+	if r.Max != nil && number > *r.Max {
+		return &FieldError{Field: field, Value: value, Kind: KindTooHigh, Reason: fmt.Sprintf("%s %s > %d", field, value, *r.Max)}
+	}
+	return nil
+}
+
+// Check validates a single value against the rule, returning the first check that fails
+func (rule FieldRule) Check(value string) *FieldError {
+	if rule.re != nil && !rule.re.MatchString(value) {
+		return &FieldError{Field: rule.Name, Value: value, Kind: KindPattern, Reason: fmt.Sprintf("%s %s does not match %s", rule.Name, value, rule.Pattern)}
+	}
+	if rule.Min != nil || rule.Max != nil {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return &FieldError{Field: rule.Name, Value: value, Kind: KindNotNumber, Reason: fmt.Sprintf("%s %s is not a number", rule.Name, value)}
+		}
+		if fieldErr := rule.Range.check(rule.Name, value, number); fieldErr != nil {
+			return fieldErr
+		}
+	}
+	if len(rule.Units) > 0 {
+		unit := strings.TrimLeft(value, "0123456789")
+		number, err := strconv.Atoi(value[:len(value)-len(unit)])
+		if err != nil {
+			return &FieldError{Field: rule.Name, Value: value, Kind: KindNotNumber, Reason: fmt.Sprintf("%s %s does not start with a number", rule.Name, value)}
+		}
+		if unit == "" {
+			return &FieldError{Field: rule.Name, Value: value, Kind: KindNoUnit, Reason: fmt.Sprintf("%s %s has no unit", rule.Name, value)}
+		}
+		unitRange, ok := rule.Units[unit]
+		if !ok {
+			return &FieldError{Field: rule.Name, Value: value, Kind: KindUnknownUnit, Reason: fmt.Sprintf("%s %s has unknown unit %s", rule.Name, value, unit)}
+		}
+		if fieldErr := unitRange.check(rule.Name, value, number); fieldErr != nil {
+			return fieldErr
+		}
+	}
+	if len(rule.Enum) > 0 {
+		found := false
+		for _, allowed := range rule.Enum {
+			if value == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &FieldError{Field: rule.Name, Value: value, Kind: KindNotInEnum, Reason: fmt.Sprintf("%s %s is not one of %s", rule.Name, value, strings.Join(rule.Enum, " "))}
+		}
+	}
+	return nil
+}
+
+// Known returns the names of every field in the schema
+func (schema Schema) Known() map[string]struct{} {
+	known := make(map[string]struct{})
+	for _, rule := range schema.Fields {
+		known[rule.Name] = struct{}{}
+	}
+	return known
+}
+
+// Missing lists every required field that the passport does not have
+func (schema Schema) Missing(fields map[string]string) []FieldError {
+	var missing []FieldError
+	for _, rule := range schema.Fields {
+		if _, ok := fields[rule.Name]; rule.Required && !ok {
+			missing = append(missing, FieldError{Field: rule.Name, Missing: true, Kind: KindMissing, Reason: rule.Name + " is missing"})
+		}
+	}
+	return missing
+}
+
+// ValidateRules lists every way the passport breaks the schema's cross-field rules
+func (schema Schema) ValidateRules(fields map[string]string) []RuleError {
+	var failures []RuleError
+	for _, rule := range schema.Rules {
+		failures = append(failures, rule.Check(fields)...)
+	}
+	return failures
+}
+
+// Valid reports whether the passport passes every field and every rule in the schema
+func (schema Schema) Valid(fields map[string]string) bool {
+	return len(schema.Validate(fields)) == 0 && len(schema.ValidateRules(fields)) == 0
+}
+
+// Validate lists every required field that is missing and every given field that fails its rule
+// Fields that are not in the schema at all are left alone
+func (schema Schema) Validate(fields map[string]string) []FieldError {
+	failures := schema.Missing(fields)
+	for _, rule := range schema.Fields {
+		if value, ok := fields[rule.Name]; ok {
+			if fieldErr := rule.Check(value); fieldErr != nil {
+				failures = append(failures, *fieldErr)
+			}
+		}
+	}
+	return failures
+}
+
+// RecordReport is the validation result for a single passport record
+type RecordReport struct {
+	Line    int           `json:"line"`
+	Valid   bool          `json:"valid"`
+	Missing []string      `json:"missing,omitempty"`
+	Invalid []FieldError  `json:"invalid,omitempty"`
+	Broken  []RuleError   `json:"broken,omitempty"`
+	Issues  []RecordIssue `json:"issues,omitempty"`
+}
+
+// ReasonCount is the number of times one field failed one kind of check
+type ReasonCount struct {
+	Field string `json:"field"`
+	Kind  string `json:"kind"`
+	Count int    `json:"count"`
+}
+
+// ValidationReport collects the results of every record along with a tally of the failures
+type ValidationReport struct {
+	Records []RecordReport `json:"records"`
+	Valid   int            `json:"valid"`
+	Invalid int            `json:"invalid"`
+	Reasons []ReasonCount  `json:"reasons"`
+}
+
+// NewRecordReport validates a record's fields against the schema, splitting missing fields from invalid ones
+// Issues found while parsing are carried along, but do not make the record invalid by themselves
+func NewRecordReport(schema Schema, record Record) RecordReport {
+	report := RecordReport{Line: record.Start.Line, Issues: record.Issues}
+	for _, failure := range schema.Validate(record.Fields) {
+		if failure.Missing {
+			report.Missing = append(report.Missing, failure.Field)
+		} else {
+			report.Invalid = append(report.Invalid, failure)
+		}
+	}
+	report.Broken = schema.ValidateRules(record.Fields)
+	report.Valid = len(report.Missing) == 0 && len(report.Invalid) == 0 && len(report.Broken) == 0
+	return report
+}
+
+// Add appends a record to the report and tallies its failures
+func (report *ValidationReport) Add(record RecordReport) {
+	report.Records = append(report.Records, record)
+	if record.Valid {
+		report.Valid++
+	} else {
+		report.Invalid++
+	}
+	tally := func(field, kind string) {
+		for i := range report.Reasons {
+			if report.Reasons[i].Field == field && report.Reasons[i].Kind == kind {
+				report.Reasons[i].Count++
+				return
+			}
+		}
+		report.Reasons = append(report.Reasons, ReasonCount{field, kind, 1})
+	}
+	for _, field := range record.Missing {
+		tally(field, KindMissing)
+	}
+	for _, failure := range record.Invalid {
+		tally(failure.Field, failure.Kind)
+	}
+	for _, failure := range record.Broken {
+		tally(strings.Join(failure.Fields, "+"), "rule "+failure.Rule)
+	}
+	// Most common first; ties keep the order they were first seen in
+	sort.SliceStable(report.Reasons, func(i, j int) bool {
+		return report.Reasons[i].Count > report.Reasons[j].Count
+	})
+}
+
+// WriteText writes the report in a readable form, one line per record and then the summary
+func (report ValidationReport) WriteText(writer io.Writer) error {
+	for _, record := range report.Records {
+		status := "VALID"
+		if !record.Valid {
+			status = "INVALID"
+		}
+		parts := []string{fmt.Sprintf("Line %d | %s", record.Line, status)}
+		if len(record.Missing) > 0 {
+			parts = append(parts, "Missing: "+strings.Join(record.Missing, ", "))
+		}
+		if len(record.Invalid) > 0 {
+			var reasons []string
+			for _, failure := range record.Invalid {
+				reasons = append(reasons, failure.Reason)
+			}
+			parts = append(parts, "Invalid: "+strings.Join(reasons, "; "))
+		}
+		if len(record.Broken) > 0 {
+			var reasons []string
+			for _, failure := range record.Broken {
+				reasons = append(reasons, failure.Rule+": "+failure.Reason)
+			}
+			parts = append(parts, "Rules: "+strings.Join(reasons, "; "))
+		}
+		if len(record.Issues) > 0 {
+			var issues []string
+			for _, issue := range record.Issues {
+				issues = append(issues, fmt.Sprintf("%d:%d %s", issue.Line, issue.Column, issue.Message))
+			}
+			parts = append(parts, "Issues: "+strings.Join(issues, "; "))
+		}
+		if _, err := fmt.Fprintln(writer, strings.Join(parts, " | ")); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(writer, "Records: %d | Valid: %d | Invalid: %d\n", len(report.Records), report.Valid, report.Invalid); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(writer, "Most common failures:"); err != nil {
+		return err
+	}
+	for _, reason := range report.Reasons {
+		if _, err := fmt.Fprintf(writer, "%6d | %s %s\n", reason.Count, reason.Field, reason.Kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the report as a single indented JSON document
+func (report ValidationReport) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(report)
 }
 
 func main() {
+	schemaPath := flag.String("schema", SchemaFilePath, "path to the JSON validation schema")
+	reportFormat := flag.String("report", "", "write a per-record validation report to stdout, as text or json, instead of the counts")
+	export := flag.String("export", "", "write the passports to stdout as csv, json or batch instead of the counts")
+	validOnly := flag.Bool("valid-only", false, "only export passports that pass the schema")
+	flag.Parse()
+
 	// P1: If reading by line, then an empty line signifies the end of a record.
 	// A Passport struct will be required. This allows us to toss instances out of memory when we don't need them anymore.
 	// Every field is known to follow key:value syntax, separated by some kind of whitespace (until the EOL is encountered)
-	// Now, a regex can be applied on every line for the expected keys.
-	// If a match is returned by FindAllString, then splitting the resulting match on : and taking the second element
-	//   results in the desired value.
-	// If there is no second element, however, then that means the key was specified, but there is no value.
-	// If any field is nil (except cid, currently optional), then the password is invalid.
+	// Rather than a regex per expected key, every token is split on its first : into a key and a value.
+	// If there is no value, however, then that means the key was specified, but there is no value - so it counts as missing.
+	// Keys that the schema doesn't know about and keys given twice are flagged rather than silently dropped,
+	//   and the last record counts even if the file doesn't end with an empty line.
+	// If any required field in the schema is missing (cid is the only optional one), then the passport is invalid.
 
-	// P2: To reiterate:
+	// P2: Every field has strict rules about what values are valid. These used to be a chain of regexes and range checks right here,
+	// but they now live in the schema file, so that a change in policy is only a change to the schema:
 	// BYR: must be 4 digits, 1920-2002
 	// IYR: must be 4 digits, 2010-2020
 	// EYR: must be 4 digits, 2020-2030
-	// HGT: must be 2/3 digits and then cm or in; 150-193 cm or 59-76 in
+	// HGT: must be digits and then cm or in; 150-193 cm or 59-76 in
 	// HCL: must be # followed by 6 hex digits
 	// ECL: must be one of amb, blu, brn, gry, grn, hzl, oth
 	// PID: must be 9 digits including leading digits
-	// CID: Ignored.
+	// CID: Optional, and not checked.
+	// The schema may also have rules that span fields, e.g. eyr after iyr, but the puzzle's own policy doesn't need any.
+	schema, err := LoadSchema(*schemaPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Reader
 	path := "./input.txt"
@@ -59,130 +915,87 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	scanner := bufio.NewScanner(buf)
+	defer buf.Close()
 
 	// Retrieve input
-	var input []string
-	for scanner.Scan() {
-		input = append(input, scanner.Text())
+	records, err := ParseRecords(buf, schema.Known())
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Regexes
-	reByrField := regexp.MustCompile("byr:[^\\s]+")
-	reIyrField := regexp.MustCompile("iyr:[^\\s]+")
-	reEyrField := regexp.MustCompile("eyr:[^\\s]+")
-	reHgtField := regexp.MustCompile("hgt:[^\\s]+")
-	reHclField := regexp.MustCompile("hcl:[^\\s]+")
-	reEclField := regexp.MustCompile("ecl:[^\\s]+")
-	rePidField := regexp.MustCompile("pid:[^\\s]+")
-	reCidField := regexp.MustCompile("cid:[^\\s]+")
-
-	reByrValue := regexp.MustCompile("^\\d{4}$")
-	reIyrValue := regexp.MustCompile("^\\d{4}$")
-	reEyrValue := regexp.MustCompile("^\\d{4}$")
-	reHgtValue := regexp.MustCompile("^\\d{3}cm$|^\\d{2}in$")
-	reHclValue := regexp.MustCompile("^#[0-9a-f]{6}$")
-	reEclValue := regexp.MustCompile("^(amb|blu|brn|gry|grn|hzl|oth)$")
-	rePidValue := regexp.MustCompile("^\\d{9}$")
+	if *reportFormat != "" && *reportFormat != "text" && *reportFormat != "json" {
+		log.Fatal("Report format should be text or json, it was ", *reportFormat)
+	}
 
-	reHgtNum := regexp.MustCompile("\\d{2,3}")
+	// Exporting replaces the counts; unknown keys have no place in a Passport, so they are not exported
+	if *export != "" {
+		var passports []Passport
+		var normalized []NormalizedPassport
+		for _, record := range records {
+			if *validOnly && !schema.Valid(record.Fields) {
+				continue
+			}
+			passport := NewPassport(record.Fields)
+			passports = append(passports, passport)
+			normalized = append(normalized, passport.Normalize(record.Start.Line))
+		}
+		out := bufio.NewWriter(os.Stdout)
+		written := len(passports)
+		switch *export {
+		case "csv":
+			err = WriteNormalizedCSV(out, normalized)
+		case "json":
+			err = WriteNormalizedJSON(out, normalized)
+		case "batch":
+			written, err = WriteBatch(out, passports)
+		default:
+			log.Fatal("Export format should be csv, json or batch, it was ", *export)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := out.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		log.Print(fmt.Sprintf("EXPORT | %s | Passports: %d", *export, written))
+		if left := len(passports) - written; left > 0 {
+			log.Print(fmt.Sprintf("EXPORT | %s | Left out with no known fields: %d", *export, left))
+		}
+		return
+	}
 
 	// Search
 	validPassportsP1 := 0
 	validPassportsP2 := 0
-	var passport Passport
-	resetPassport(&passport)
-	for _, line := range input {
-		if line == "" {
-			if passport.byr != "" && passport.ecl != "" && passport.eyr != "" && passport.hcl != "" && passport.hgt != "" && passport.iyr != "" && passport.pid != "" {
-				validPassportsP1++
-
-				// log.Print(fmt.Sprintf("P1 | DEBUG | BYR %s | IYR %s | EYR %s | HGT %s | HCL %s | ECL %s | PID %s", passport.byr, passport.iyr, passport.eyr, passport.hgt, passport.hcl, passport.ecl, passport.pid))
-
-				passport.byr = reByrValue.FindString(passport.byr)
-				passport.iyr = reIyrValue.FindString(passport.iyr)
-				passport.eyr = reEyrValue.FindString(passport.eyr)
-				passport.hgt = reHgtValue.FindString(passport.hgt)
-				if len(strings.Split(passport.hcl, "")) == 7 {
-					passport.hcl = reHclValue.FindString(passport.hcl)
-				} else {
-					passport.hcl = ""
-				}
-				if len(strings.Split(passport.ecl, "")) == 3 {
-					passport.ecl = reEclValue.FindString(passport.ecl)
-				} else {
-					passport.ecl = ""
-				}
-				passport.pid = rePidValue.FindString(passport.pid)
-
-				if passport.byr != "" && passport.ecl != "" && passport.eyr != "" && passport.hcl != "" && passport.hgt != "" && passport.iyr != "" && passport.pid != "" {
-					byrValue, err := strconv.Atoi(passport.byr)
-					if err != nil {
-						log.Fatal(err)
-					}
-					iyrValue, err := strconv.Atoi(passport.iyr)
-					if err != nil {
-						log.Fatal(err)
-					}
-					eyrValue, err := strconv.Atoi(passport.eyr)
-					if err != nil {
-						log.Fatal(err)
-					}
-					hgtNum, err := strconv.Atoi(reHgtNum.FindString(passport.hgt))
-					if byrValue >= 1920 && byrValue <= 2002 && iyrValue >= 2010 && iyrValue <= 2020 && eyrValue >= 2020 && eyrValue <= 2030 {
-						log.Print(fmt.Sprintf("P2 | DEBUG | BYR %s | IYR %s | EYR %s | HGT %s | HCL %s | ECL %s | PID %s", passport.byr, passport.iyr, passport.eyr, passport.hgt, passport.hcl, passport.ecl, passport.pid))
-
-						inCm, err := regexp.MatchString("cm", passport.hgt)
-						if err != nil {
-							log.Fatal(err)
-						}
-						inIn, err := regexp.MatchString("in", passport.hgt)
-						if err != nil {
-							log.Fatal(err)
-						}
-						if (hgtNum >= 150 && hgtNum <= 193 && inCm) || (hgtNum >= 59 && hgtNum <= 76 && inIn) {
-							validPassportsP2++
-						}
-					}
-				}
-			}
-			resetPassport(&passport)
-			continue
-		}
-
-		byr := strings.Split(reByrField.FindString(line), ":")
-		iyr := strings.Split(reIyrField.FindString(line), ":")
-		eyr := strings.Split(reEyrField.FindString(line), ":")
-		hgt := strings.Split(reHgtField.FindString(line), ":")
-		hcl := strings.Split(reHclField.FindString(line), ":")
-		ecl := strings.Split(reEclField.FindString(line), ":")
-		pid := strings.Split(rePidField.FindString(line), ":")
-		cid := strings.Split(reCidField.FindString(line), ":")
-
-		if len(byr) > 1 {
-			passport.byr = byr[1]
+	var report ValidationReport
+	for _, record := range records {
+		if len(schema.Missing(record.Fields)) == 0 {
+			validPassportsP1++
 		}
-		if len(iyr) > 1 {
-			passport.iyr = iyr[1]
+		if schema.Valid(record.Fields) {
+			validPassportsP2++
 		}
-		if len(eyr) > 1 {
-			passport.eyr = eyr[1]
-		}
-		if len(hgt) > 1 {
-			passport.hgt = hgt[1]
-		}
-		if len(hcl) > 1 {
-			passport.hcl = hcl[1]
+		report.Add(NewRecordReport(schema, record))
+		if *reportFormat == "" {
+			for _, issue := range record.Issues {
+				log.Print(fmt.Sprintf("PARSE | Line %d | Column %d | %s", issue.Line, issue.Column, issue.Message))
+			}
 		}
-		if len(ecl) > 1 {
-			passport.ecl = ecl[1]
+	}
+	if *reportFormat != "" {
+		out := bufio.NewWriter(os.Stdout)
+		if *reportFormat == "json" {
+			err = report.WriteJSON(out)
+		} else {
+			err = report.WriteText(out)
 		}
-		if len(pid) > 1 {
-			passport.pid = pid[1]
+		if err != nil {
+			log.Fatal(err)
 		}
-		if len(cid) > 1 {
-			passport.cid = cid[1]
+		if err := out.Flush(); err != nil {
+			log.Fatal(err)
 		}
+		return
 	}
 	log.Print(fmt.Sprintf("P1 | Valid passports: %d", validPassportsP1))
 	log.Print(fmt.Sprintf("P2 | Valid passports: %d", validPassportsP2))
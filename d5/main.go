@@ -2,14 +2,352 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
+// Seat is a decoded boarding pass
+type Seat struct {
+	Row    int
+	Column int
+	ID     int
+}
+
+// SeatCodec converts between boarding passes and seats for a plane of any size
+// Each letter of a pass halves the remaining rows or columns, so both counts have to be powers of two
+// RowLetters and ColumnLetters are two letters each: the first keeps the lower half and the second the upper half
+type SeatCodec struct {
+	Rows          int
+	Columns       int
+	RowLetters    string
+	ColumnLetters string
+	rowChars      int
+	columnChars   int
+}
+
+// NewSeatCodec checks the plane's dimensions and letters and works out how long a pass is
+func NewSeatCodec(rows, columns int, rowLetters, columnLetters string) (SeatCodec, error) {
+	codec := SeatCodec{Rows: rows, Columns: columns, RowLetters: rowLetters, ColumnLetters: columnLetters}
+	var err error
+	if codec.rowChars, err = halvings(rows); err != nil {
+		return codec, fmt.Errorf("rows: %v", err)
+	}
+	if codec.columnChars, err = halvings(columns); err != nil {
+		return codec, fmt.Errorf("columns: %v", err)
+	}
+	if len(rowLetters) != 2 || len(columnLetters) != 2 {
+		return codec, errors.New("row and column letters should be two letters each, lower half first")
+	}
+	letters := rowLetters + columnLetters
+	for i := 0; i < len(letters); i++ {
+		if strings.IndexByte(letters[i+1:], letters[i]) >= 0 {
+			return codec, fmt.Errorf("letter %q is used twice in %s and %s", letters[i], rowLetters, columnLetters)
+		}
+	}
+	return codec, nil
+}
+
+// halvings returns how many times n can be halved until it is 1, or an error if n is not a power of two
+func halvings(n int) (int, error) {
+	if n < 1 || n&(n-1) != 0 {
+		return 0, fmt.Errorf("%d is not a power of two", n)
+	}
+	count := 0
+	for n > 1 {
+		n /= 2
+		count++
+	}
+	return count, nil
+}
+
+// PassLength is the number of letters in a boarding pass
+func (codec SeatCodec) PassLength() int {
+	return codec.rowChars + codec.columnChars
+}
+
+// SeatID is the ID of the seat at the given row and column
+func (codec SeatCodec) SeatID(row, column int) int {
+	return row*codec.Columns + column
+}
+
+// Decode narrows down the row with the first letters of the pass and the column with the rest
+func (codec SeatCodec) Decode(pass string) (Seat, error) {
+	if len(pass) != codec.PassLength() {
+		return Seat{}, fmt.Errorf("pass %q has %d letters, expected %d", pass, len(pass), codec.PassLength())
+	}
+	// Taking the upper half is the same as setting a bit, so the row and column are just binary numbers
+	row := 0
+	for i := 0; i < codec.rowChars; i++ {
+		switch pass[i] {
+		case codec.RowLetters[0]:
+			row = row * 2
+		case codec.RowLetters[1]:
+			row = row*2 + 1
+		default:
+			return Seat{}, fmt.Errorf("pass %q has %q at position %d, expected %c or %c", pass, pass[i], i+1, codec.RowLetters[0], codec.RowLetters[1])
+		}
+	}
+	column := 0
+	for i := codec.rowChars; i < len(pass); i++ {
+		switch pass[i] {
+		case codec.ColumnLetters[0]:
+			column = column * 2
+		case codec.ColumnLetters[1]:
+			column = column*2 + 1
+		default:
+			return Seat{}, fmt.Errorf("pass %q has %q at position %d, expected %c or %c", pass, pass[i], i+1, codec.ColumnLetters[0], codec.ColumnLetters[1])
+		}
+	}
+	return Seat{row, column, codec.SeatID(row, column)}, nil
+}
+
+// Encode writes the boarding pass for the seat at the given row and column
+func (codec SeatCodec) Encode(row, column int) (string, error) {
+	if row < 0 || row >= codec.Rows {
+		return "", fmt.Errorf("row %d is outside 0-%d", row, codec.Rows-1)
+	}
+	if column < 0 || column >= codec.Columns {
+		return "", fmt.Errorf("column %d is outside 0-%d", column, codec.Columns-1)
+	}
+	pass := make([]byte, codec.PassLength())
+	for i := codec.rowChars - 1; i >= 0; i-- {
+		pass[i] = codec.RowLetters[row%2]
+		row /= 2
+	}
+	for i := len(pass) - 1; i >= codec.rowChars; i-- {
+		pass[i] = codec.ColumnLetters[column%2]
+		column /= 2
+	}
+	return string(pass), nil
+}
+
+// EncodeID writes the boarding pass for the seat with the given ID
+func (codec SeatCodec) EncodeID(id int) (string, error) {
+	if id < 0 || id >= codec.Rows*codec.Columns {
+		return "", fmt.Errorf("seat ID %d is outside 0-%d", id, codec.Rows*codec.Columns-1)
+	}
+	return codec.Encode(id/codec.Columns, id%codec.Columns)
+}
+
+// ScannedPass is one line of input after scanning: the seat it decodes to, or why it was rejected
+// Pass is the text that was actually decoded, which differs from Raw if the scanner had to correct it
+type ScannedPass struct {
+	Line int
+	Raw  string
+	Pass string
+	Seat Seat
+	Err  error
+}
+
+// Corrected reports whether the scanner had to change the pass before decoding it
+func (scanned ScannedPass) Corrected() bool {
+	return scanned.Err == nil && scanned.Pass != scanned.Raw
+}
+
+// Normalize removes whitespace from a pass and matches every other character to the codec's letters regardless of case
+// Characters that match no letter are left alone, for Decode to reject
+func (codec SeatCodec) Normalize(raw string) string {
+	letters := codec.RowLetters + codec.ColumnLetters
+	var builder strings.Builder
+	for _, char := range raw {
+		if unicode.IsSpace(char) {
+			continue
+		}
+		matched := false
+		for i := 0; i < len(letters); i++ {
+			if strings.EqualFold(string(char), letters[i:i+1]) {
+				builder.WriteByte(letters[i])
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			builder.WriteRune(char)
+		}
+	}
+	return builder.String()
+}
+
+// ScanPasses decodes every line of input, keeping track of which line each pass came from
+// In strict mode every line must already be a valid pass; in tolerant mode passes are normalized first and empty lines are skipped
+// Either way, a line that still does not decode is returned with its error rather than dropped
+func ScanPasses(input []string, codec SeatCodec, tolerant bool) []ScannedPass {
+	var scanned []ScannedPass
+	for lineN, line := range input {
+		pass := line
+		if tolerant {
+			pass = codec.Normalize(line)
+			if pass == "" {
+				continue
+			}
+		}
+		seat, err := codec.Decode(pass)
+		scanned = append(scanned, ScannedPass{lineN + 1, line, pass, seat, err})
+	}
+	return scanned
+}
+
+// SeatMap records which seats on the plane have a boarding pass, and which input lines each pass came from
+type SeatMap struct {
+	Codec  SeatCodec
+	Passes map[int][]int
+}
+
+// NewSeatMap makes an empty seat map for the codec's plane
+func NewSeatMap(codec SeatCodec) SeatMap {
+	return SeatMap{codec, make(map[int][]int)}
+}
+
+// Add marks a seat as occupied by the pass on the given line
+func (seatMap SeatMap) Add(seat Seat, line int) {
+	seatMap.Passes[seat.ID] = append(seatMap.Passes[seat.ID], line)
+}
+
+// Occupied reports whether any pass is for the seat with the given ID
+func (seatMap SeatMap) Occupied(id int) bool {
+	return len(seatMap.Passes[id]) > 0
+}
+
+// rowOccupied reports whether any seat in the row is occupied
+func (seatMap SeatMap) rowOccupied(row int) bool {
+	for column := 0; column < seatMap.Codec.Columns; column++ {
+		if seatMap.Occupied(seatMap.Codec.SeatID(row, column)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Render draws the plane a row per line, with # for an occupied seat and . for a free one
+// Duplicated seats are drawn with the number of passes for them instead (or + for ten or more)
+func (seatMap SeatMap) Render() []string {
+	var lines []string
+	width := len(strconv.Itoa(seatMap.Codec.Rows - 1))
+	for row := 0; row < seatMap.Codec.Rows; row++ {
+		var chars []string
+		for column := 0; column < seatMap.Codec.Columns; column++ {
+			count := len(seatMap.Passes[seatMap.Codec.SeatID(row, column)])
+			switch {
+			case count == 0:
+				chars = append(chars, ".")
+			case count == 1:
+				chars = append(chars, "#")
+			case count < 10:
+				chars = append(chars, strconv.Itoa(count))
+			default:
+				chars = append(chars, "+")
+			}
+		}
+		lines = append(lines, fmt.Sprintf("Row %*d | %s", width, row, strings.Join(chars, "")))
+	}
+	return lines
+}
+
+// FreeBetween lists every free seat whose IDs on both sides are occupied - these are the candidates for your seat in P2
+func (seatMap SeatMap) FreeBetween() []Seat {
+	var seats []Seat
+	for id := 1; id < seatMap.Codec.Rows*seatMap.Codec.Columns-1; id++ {
+		if !seatMap.Occupied(id) && seatMap.Occupied(id-1) && seatMap.Occupied(id+1) {
+			seats = append(seats, Seat{id / seatMap.Codec.Columns, id % seatMap.Codec.Columns, id})
+		}
+	}
+	return seats
+}
+
+// MissingRows lists the completely empty rows at the very front and the very back of the plane
+// If there are no passes at all, every row is counted as missing from the front
+func (seatMap SeatMap) MissingRows() ([]int, []int) {
+	var front, back []int
+	row := 0
+	for ; row < seatMap.Codec.Rows && !seatMap.rowOccupied(row); row++ {
+		front = append(front, row)
+	}
+	for last := seatMap.Codec.Rows - 1; last > row && !seatMap.rowOccupied(last); last-- {
+		back = append([]int{last}, back...)
+	}
+	return front, back
+}
+
+// Duplicates returns every seat ID that more than one pass is for, along with the lines of those passes, lowest ID first
+func (seatMap SeatMap) Duplicates() ([]int, map[int][]int) {
+	var ids []int
+	duplicates := make(map[int][]int)
+	for id, lines := range seatMap.Passes {
+		if len(lines) > 1 {
+			ids = append(ids, id)
+			duplicates[id] = lines
+		}
+	}
+	sort.Ints(ids)
+	return ids, duplicates
+}
+
+// rowSpan writes a list of rows compactly, e.g. 0-5
+func rowSpan(rows []int) string {
+	if len(rows) == 0 {
+		return "none"
+	}
+	if len(rows) == 1 {
+		return strconv.Itoa(rows[0])
+	}
+	return fmt.Sprintf("%d-%d (%d rows)", rows[0], rows[len(rows)-1], len(rows))
+}
+
 func main() {
+	rows := flag.Int("rows", 128, "number of rows on the plane")
+	columns := flag.Int("columns", 8, "number of seats in each row")
+	rowLetters := flag.String("row-letters", "FB", "letters for the lower and upper half of the rows")
+	columnLetters := flag.String("column-letters", "LR", "letters for the lower and upper half of the columns")
+	decode := flag.String("decode", "", "decode this boarding pass instead of solving the puzzle")
+	encode := flag.String("encode", "", "encode a seat ID, or a row and column as R,C, into a boarding pass instead of solving the puzzle")
+	seatMapMode := flag.Bool("seatmap", false, "draw the plane and report free seats, missing rows and duplicate passes instead of solving the puzzle")
+	tolerant := flag.Bool("tolerant", false, "fix the case of and whitespace in passes, and skip passes that still do not decode instead of stopping")
+	flag.Parse()
+
+	codec, err := NewSeatCodec(*rows, *columns, *rowLetters, *columnLetters)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Single passes don't need the input at all
+	if *decode != "" {
+		seat, err := codec.Decode(*decode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Print(fmt.Sprintf("DECODE | %s | Row: %d | Column: %d | Seat ID: %d", *decode, seat.Row, seat.Column, seat.ID))
+		return
+	}
+	if *encode != "" {
+		var pass string
+		if split := strings.Split(*encode, ","); len(split) == 2 {
+			row, errRow := strconv.Atoi(strings.TrimSpace(split[0]))
+			column, errColumn := strconv.Atoi(strings.TrimSpace(split[1]))
+			if errRow != nil || errColumn != nil {
+				log.Fatal("Encode needs a seat ID or R,C; got ", *encode)
+			}
+			pass, err = codec.Encode(row, column)
+		} else {
+			id, errID := strconv.Atoi(strings.TrimSpace(*encode))
+			if errID != nil {
+				log.Fatal("Encode needs a seat ID or R,C; got ", *encode)
+			}
+			pass, err = codec.EncodeID(id)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Print(fmt.Sprintf("ENCODE | %s | Pass: %s", *encode, pass))
+		return
+	}
+
 	// Reader
 	path := "./input.txt"
 	buf, err := os.Open(path)
@@ -27,32 +365,64 @@ func main() {
 	// P1: For each boarding pass, set up a low of 0 and a high of 127. The diff, plus 1, indicates the potential row count.
 	// The same can be said for seat column: set up a low of 0 and a high of 7. The diff, plus 1, indicates the potential column count.
 	// Halving the row/column count indicates the size of the next slice.
+	// Taking the upper half every time is the same as setting a bit, so the codec reads the row and column as binary numbers.
+	// This also means the plane doesn't have to be 128 by 8 - any powers of two will do.
 
 	// In P1, the number of interest is the highest seat ID - which is row * 8 + column.
 	// If the plane were full, then the highest potential seat ID could be 127 * 8 + 7, or 1023.
-	maxSeatID := 0
-	for _, line := range input {
-		rowLow := 0
-		rowHigh := 127
-		columnLow := 0
-		columnHigh := 7
-		splits := strings.Split(line, "")
-		for _, char := range splits {
-			rowCount := (rowHigh - rowLow + 1) / 2
-			columnCount := (columnHigh - columnLow + 1) / 2
-			switch c := char; c {
-			case "F":
-				rowHigh -= rowCount
-			case "B":
-				rowLow += rowCount
-			case "L":
-				columnHigh -= columnCount
-			case "R":
-				columnLow += columnCount
+	// Every pass is scanned first, so no seat ID is ever worked out from a pass that didn't decode
+	var seats []Seat
+	var seatLines []int
+	rejected := 0
+	for _, scanned := range ScanPasses(input, codec, *tolerant) {
+		if scanned.Err != nil {
+			if !*tolerant {
+				log.Fatal(fmt.Sprintf("Line %d: %v", scanned.Line, scanned.Err))
 			}
+			log.Print(fmt.Sprintf("SCAN | Line %d | Rejected %q | %v", scanned.Line, scanned.Raw, scanned.Err))
+			rejected++
+			continue
 		}
-		if maxSeatID < rowLow*8+columnLow {
-			maxSeatID = rowLow*8 + columnLow
+		if scanned.Corrected() {
+			log.Print(fmt.Sprintf("SCAN | Line %d | Corrected %q to %s", scanned.Line, scanned.Raw, scanned.Pass))
+		}
+		seats = append(seats, scanned.Seat)
+		seatLines = append(seatLines, scanned.Line)
+	}
+	if *tolerant {
+		log.Print(fmt.Sprintf("SCAN | Accepted: %d | Rejected: %d", len(seats), rejected))
+	}
+	// -seatmap prints the map instead of the P1 and P2 answers
+	if *seatMapMode {
+		seatMap := NewSeatMap(codec)
+		for index, seat := range seats {
+			seatMap.Add(seat, seatLines[index])
+		}
+		for _, line := range seatMap.Render() {
+			log.Println(line)
+		}
+		front, back := seatMap.MissingRows()
+		log.Println("SEATMAP | Missing front rows:", rowSpan(front))
+		log.Println("SEATMAP | Missing back rows:", rowSpan(back))
+		free := seatMap.FreeBetween()
+		for _, seat := range free {
+			log.Print(fmt.Sprintf("SEATMAP | Free seat between occupied seats | Row: %d | Column: %d | Seat ID: %d", seat.Row, seat.Column, seat.ID))
+		}
+		if len(free) == 0 {
+			log.Println("SEATMAP | No free seat between occupied seats")
+		}
+		ids, duplicates := seatMap.Duplicates()
+		for _, id := range ids {
+			log.Print(fmt.Sprintf("SEATMAP | Duplicate seat ID: %d | Lines: %v", id, duplicates[id]))
+		}
+		log.Print(fmt.Sprintf("SEATMAP | Passes: %d | Seats occupied: %d | Duplicated seats: %d", len(seats), len(seatMap.Passes), len(ids)))
+		return
+	}
+
+	maxSeatID := 0
+	for _, seat := range seats {
+		if maxSeatID < seat.ID {
+			maxSeatID = seat.ID
 		}
 	}
 	log.Print(fmt.Sprintf("P1 | MAX SEAT ID: %d", maxSeatID))
@@ -60,33 +430,20 @@ func main() {
 	// P2: There is a naive way of doing this, which is to gather all the seat IDs, sort them, and then skip along until the next ID is missing
 	// Which is probably the easiest way of handling this, tbh.
 	var seatIDs []int
-	for _, line := range input {
-		rowLow := 0
-		rowHigh := 127
-		columnLow := 0
-		columnHigh := 7
-		splits := strings.Split(line, "")
-		for _, char := range splits {
-			rowCount := (rowHigh - rowLow + 1) / 2
-			columnCount := (columnHigh - columnLow + 1) / 2
-			switch c := char; c {
-			case "F":
-				rowHigh -= rowCount
-			case "B":
-				rowLow += rowCount
-			case "L":
-				columnHigh -= columnCount
-			case "R":
-				columnLow += columnCount
-			}
-		}
-		seatIDs = append(seatIDs, rowLow*8+columnLow)
+	for _, seat := range seats {
+		seatIDs = append(seatIDs, seat.ID)
 	}
+	// Stop one short of the end, as the last ID has no next ID to compare with
 	sort.Ints(seatIDs)
-	for index, seatID := range seatIDs {
-		if seatIDs[index+1]-seatID > 1 {
-			log.Print(fmt.Sprintf("P2 | BOOKED SEAT ID: %d", seatID+1))
+	found := false
+	for index := 0; index+1 < len(seatIDs); index++ {
+		if seatIDs[index+1]-seatIDs[index] > 1 {
+			log.Print(fmt.Sprintf("P2 | BOOKED SEAT ID: %d", seatIDs[index]+1))
+			found = true
 			break
 		}
 	}
+	if !found {
+		log.Print("P2 | NO GAP IN SEAT IDS")
+	}
 }
@@ -2,75 +2,445 @@ package main
 
 import (
 	"bufio"
+	"encoding/csv"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/bits"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// Answers is the set of questions one person answered yes to, as a bitset with bit 0 for a through bit 25 for z
+type Answers uint32
+
+// Group is the answers of every person in a group, along with the line the group starts on
+type Group struct {
+	Line   int
+	People []Answers
+}
+
+// Count is the number of questions in the set
+func (answers Answers) Count() int {
+	return bits.OnesCount32(uint32(answers))
+}
+
+// String writes the set as its letters in order, e.g. "abx"
+func (answers Answers) String() string {
+	var builder strings.Builder
+	for q := 0; q < 26; q++ {
+		if answers&(1<<q) != 0 {
+			builder.WriteByte(byte('a' + q))
+		}
+	}
+	return builder.String()
+}
+
+// ParseAnswers reads one person's line of answers
+func ParseAnswers(line string) (Answers, error) {
+	var answers Answers
+	for i := 0; i < len(line); i++ {
+		if line[i] < 'a' || line[i] > 'z' {
+			return 0, fmt.Errorf("%q at column %d is not a question from a to z", line[i], i+1)
+		}
+		answers |= 1 << (line[i] - 'a')
+	}
+	return answers, nil
+}
+
+// ReadGroups splits the input into groups separated by empty lines, one person per line
+// The last group is kept whether or not the input ends with an empty line
+func ReadGroups(reader io.Reader) ([]Group, error) {
+	var groups []Group
+	var current Group
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			if len(current.People) > 0 {
+				groups = append(groups, current)
+			}
+			current = Group{}
+			continue
+		}
+		answers, err := ParseAnswers(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		if len(current.People) == 0 {
+			current.Line = lineNumber
+		}
+		current.People = append(current.People, answers)
+	}
+	if len(current.People) > 0 {
+		groups = append(groups, current)
+	}
+	return groups, scanner.Err()
+}
+
+// Tally counts how many people in the group answered each question
+func (group Group) Tally() [26]int {
+	var tally [26]int
+	for _, person := range group.People {
+		for q := 0; q < 26; q++ {
+			if person&(1<<q) != 0 {
+				tally[q]++
+			}
+		}
+	}
+	return tally
+}
+
+// Where returns the set of questions whose tally passes the test
+func (group Group) Where(test func(count int) bool) Answers {
+	var answers Answers
+	for q, count := range group.Tally() {
+		if test(count) {
+			answers |= 1 << q
+		}
+	}
+	return answers
+}
+
+// Query is an aggregation over the people in a group
+type Query struct {
+	Name      string
+	Aggregate func(group Group) Answers
+}
+
+// ParseQuery reads an aggregation by name, which is one of
+// union (questions anyone answered, as in P1), intersection (questions everyone answered, as in P2),
+// atleast:K or exactly:K (questions answered by at least or exactly K people), majority (questions more than half of the group answered),
+// or symdiff (the symmetric difference of everyone's answers, i.e. questions an odd number of people answered)
+func ParseQuery(spec string) (Query, error) {
+	name := strings.TrimSpace(spec)
+	split := strings.SplitN(name, ":", 2)
+	switch split[0] {
+	case "union":
+		return Query{name, func(group Group) Answers {
+			var answers Answers
+			for _, person := range group.People {
+				answers |= person
+			}
+			return answers
+		}}, nil
+	case "intersection":
+		return Query{name, func(group Group) Answers {
+			answers := Answers(1<<26 - 1)
+			for _, person := range group.People {
+				answers &= person
+			}
+			return answers
+		}}, nil
+	case "symdiff":
+		return Query{name, func(group Group) Answers {
+			var answers Answers
+			for _, person := range group.People {
+				answers ^= person
+			}
+			return answers
+		}}, nil
+	case "majority":
+		return Query{name, func(group Group) Answers {
+			return group.Where(func(count int) bool { return count*2 > len(group.People) })
+		}}, nil
+	case "atleast", "exactly":
+		if len(split) != 2 {
+			return Query{}, fmt.Errorf("query %q needs a count, e.g. %s:2", name, split[0])
+		}
+		k, err := strconv.Atoi(split[1])
+		if err != nil || k < 1 {
+			return Query{}, fmt.Errorf("query %q needs a count of at least 1", name)
+		}
+		if split[0] == "atleast" {
+			return Query{name, func(group Group) Answers {
+				return group.Where(func(count int) bool { return count >= k })
+			}}, nil
+		}
+		return Query{name, func(group Group) Answers {
+			return group.Where(func(count int) bool { return count == k })
+		}}, nil
+	}
+	return Query{}, fmt.Errorf("unknown query %q; expected union, intersection, atleast:K, exactly:K, majority or symdiff", name)
+}
+
+// Total sums the size of the query's result over every group
+func (query Query) Total(groups []Group) int {
+	total := 0
+	for _, group := range groups {
+		total += query.Aggregate(group).Count()
+	}
+	return total
+}
+
+// AnswerStats is a summary of every answer across every group
+// CoOccurrence counts the people who answered both questions, so its diagonal is the number of people who answered each question
+type AnswerStats struct {
+	People           int
+	Groups           int
+	GroupsByQuestion [26]int
+	GroupSizes       map[int]int
+	CoOccurrence     [26][26]int
+}
+
+// NewAnswerStats tallies the answers of every person and group
+func NewAnswerStats(groups []Group) AnswerStats {
+	stats := AnswerStats{Groups: len(groups), GroupSizes: make(map[int]int)}
+	for _, group := range groups {
+		stats.GroupSizes[len(group.People)]++
+		var anyone Answers
+		for _, person := range group.People {
+			stats.People++
+			anyone |= person
+			for a := 0; a < 26; a++ {
+				if person&(1<<a) == 0 {
+					continue
+				}
+				for b := 0; b < 26; b++ {
+					if person&(1<<b) != 0 {
+						stats.CoOccurrence[a][b]++
+					}
+				}
+			}
+		}
+		for q := 0; q < 26; q++ {
+			if anyone&(1<<q) != 0 {
+				stats.GroupsByQuestion[q]++
+			}
+		}
+	}
+	return stats
+}
+
+// PeopleByQuestion is the number of people who answered the question
+func (stats AnswerStats) PeopleByQuestion(q int) int {
+	return stats.CoOccurrence[q][q]
+}
+
+// AlwaysTogether lists the pairs of questions that were only ever answered together - whoever answered one also answered the other
+// NeverTogether lists the pairs of questions that were both answered by someone, but never by the same person
+// Questions nobody answered are left out of both
+func (stats AnswerStats) AlwaysTogether() [][2]int {
+	var pairs [][2]int
+	for a := 0; a < 26; a++ {
+		for b := a + 1; b < 26; b++ {
+			both := stats.CoOccurrence[a][b]
+			if both > 0 && both == stats.PeopleByQuestion(a) && both == stats.PeopleByQuestion(b) {
+				pairs = append(pairs, [2]int{a, b})
+			}
+		}
+	}
+	return pairs
+}
+
+// NeverTogether is described with AlwaysTogether
+func (stats AnswerStats) NeverTogether() [][2]int {
+	var pairs [][2]int
+	for a := 0; a < 26; a++ {
+		for b := a + 1; b < 26; b++ {
+			if stats.CoOccurrence[a][b] == 0 && stats.PeopleByQuestion(a) > 0 && stats.PeopleByQuestion(b) > 0 {
+				pairs = append(pairs, [2]int{a, b})
+			}
+		}
+	}
+	return pairs
+}
+
+// sizes lists the group sizes that occur, smallest first
+func (stats AnswerStats) sizes() []int {
+	var sizes []int
+	for size := range stats.GroupSizes {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// percent writes part as a percentage of whole
+func percent(part, whole int) string {
+	if whole == 0 {
+		return "0.0"
+	}
+	return strconv.FormatFloat(100*float64(part)/float64(whole), 'f', 1, 64)
+}
+
+// QuestionTable has a row per question: how many people and how many groups answered it
+func (stats AnswerStats) QuestionTable() [][]string {
+	table := [][]string{{"question", "people", "people_pct", "groups", "groups_pct"}}
+	for q := 0; q < 26; q++ {
+		table = append(table, []string{string(rune('a' + q)), strconv.Itoa(stats.PeopleByQuestion(q)), percent(stats.PeopleByQuestion(q), stats.People), strconv.Itoa(stats.GroupsByQuestion[q]), percent(stats.GroupsByQuestion[q], stats.Groups)})
+	}
+	return table
+}
+
+// GroupSizeTable has a row per group size: how many groups have that many people
+func (stats AnswerStats) GroupSizeTable() [][]string {
+	table := [][]string{{"size", "groups", "groups_pct"}}
+	for _, size := range stats.sizes() {
+		table = append(table, []string{strconv.Itoa(size), strconv.Itoa(stats.GroupSizes[size]), percent(stats.GroupSizes[size], stats.Groups)})
+	}
+	return table
+}
+
+// PairTable has a row per pair of questions that were always or never answered together
+func (stats AnswerStats) PairTable() [][]string {
+	table := [][]string{{"relation", "a", "b", "people_a", "people_b", "people_both"}}
+	for relation, pairs := range map[string][][2]int{"always": stats.AlwaysTogether(), "never": stats.NeverTogether()} {
+		for _, pair := range pairs {
+			a, b := pair[0], pair[1]
+			table = append(table, []string{relation, string(rune('a' + a)), string(rune('a' + b)), strconv.Itoa(stats.PeopleByQuestion(a)), strconv.Itoa(stats.PeopleByQuestion(b)), strconv.Itoa(stats.CoOccurrence[a][b])})
+		}
+	}
+	// Map order is random, so put always before never again
+	sort.SliceStable(table[1:], func(i, j int) bool { return table[1+i][0] < table[1+j][0] })
+	return table
+}
+
+// CoOccurrenceTable is the co-occurrence matrix with a header row and column of question letters
+func (stats AnswerStats) CoOccurrenceTable() [][]string {
+	header := []string{""}
+	for q := 0; q < 26; q++ {
+		header = append(header, string(rune('a'+q)))
+	}
+	table := [][]string{header}
+	for a := 0; a < 26; a++ {
+		row := []string{string(rune('a' + a))}
+		for b := 0; b < 26; b++ {
+			row = append(row, strconv.Itoa(stats.CoOccurrence[a][b]))
+		}
+		table = append(table, row)
+	}
+	return table
+}
+
+// Tables names every table of the statistics, in the order they are printed
+func (stats AnswerStats) Tables() ([]string, map[string][][]string) {
+	return []string{"questions", "group_sizes", "pairs", "cooccurrence"}, map[string][][]string{
+		"questions":    stats.QuestionTable(),
+		"group_sizes":  stats.GroupSizeTable(),
+		"pairs":        stats.PairTable(),
+		"cooccurrence": stats.CoOccurrenceTable(),
+	}
+}
+
+// PrintTable logs a table with its columns lined up
+func PrintTable(table [][]string) {
+	widths := make([]int, len(table[0]))
+	for _, row := range table {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for _, row := range table {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprintf("%*s", widths[i], cell)
+		}
+		log.Println(strings.Join(cells, " | "))
+	}
+}
+
+// WriteStatsCSV writes each table into its own CSV file in the directory, e.g. questions.csv
+func WriteStatsCSV(dir string, stats AnswerStats) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	names, tables := stats.Tables()
+	for _, name := range names {
+		out, err := os.Create(filepath.Join(dir, name+".csv"))
+		if err != nil {
+			return err
+		}
+		writer := csv.NewWriter(out)
+		if err := writer.WriteAll(tables[name]); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func main() {
+	querySpec := flag.String("query", "", "comma-separated aggregations to run on every group instead of solving the puzzle: union, intersection, atleast:K, exactly:K, majority, symdiff")
+	statsText := flag.Bool("stats", false, "print answer statistics and co-occurrence tables instead of solving the puzzle")
+	statsCSV := flag.String("stats-csv", "", "write the answer statistics as CSV files into this directory instead of solving the puzzle")
+	flag.Parse()
+
 	// Reader
 	path := "./input.txt"
 	buf, err := os.Open(path)
 	if err != nil {
 		log.Fatal(err)
 	}
-	scanner := bufio.NewScanner(buf)
+	defer buf.Close()
 
 	// Retrieve input
-	var input []string
-	for scanner.Scan() {
-		input = append(input, scanner.Text())
+	// Every person's answers become a bitset of 26 questions, so that combining a group's answers is just bitwise logic
+	groups, err := ReadGroups(buf)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// P1: For each group, build up a slice of unique letters
-	groupAnswers := make(map[string]struct{})
-	sumYes := 0
-	for i := 0; i < len(input); i++ {
-		if len(strings.Split(input[i], "")) == 0 {
-			sumYes += len(groupAnswers)
-			// log.Println("P1 | DEBUG | Group Answers:", groupAnswers, "| Count:", len(groupAnswers))
-			groupAnswers = make(map[string]struct{})
-			continue
+	// The statistics cover every question, group and pair of questions, so they are printed without the two totals
+	if *statsText || *statsCSV != "" {
+		stats := NewAnswerStats(groups)
+		if *statsText {
+			names, tables := stats.Tables()
+			for _, name := range names {
+				log.Println("STATS |", name)
+				PrintTable(tables[name])
+			}
+			log.Print(fmt.Sprintf("STATS | People: %d | Groups: %d | Always together: %d pairs | Never together: %d pairs", stats.People, stats.Groups, len(stats.AlwaysTogether()), len(stats.NeverTogether())))
 		}
-		for _, char := range strings.Split(input[i], "") {
-			groupAnswers[char] = struct{}{}
+		if *statsCSV != "" {
+			if err := WriteStatsCSV(*statsCSV, stats); err != nil {
+				log.Fatal(err)
+			}
+			log.Println("STATS | Wrote CSV files to", *statsCSV)
 		}
+		return
 	}
-	// If there's still content in groupAnswers at this point then it's because the input doesn't contain a trailing empty string
-	if len(groupAnswers) > 0 {
-		sumYes += len(groupAnswers)
-	}
-	log.Print(fmt.Sprintf("P1 | Count of yes answers: %d", sumYes))
 
-	// P2: For each group, associate each letter with occurrences; also count up each line in the group
-	// If the group line count equals the occurrences then tick up the sum
-	groupAnswerCounts := make(map[string]int)
-	groupSize := 0
-	sumAllYes := 0
-	for i := 0; i < len(input); i++ {
-		if len(strings.Split(input[i], "")) == 0 {
-			for _, count := range groupAnswerCounts {
-				if count == groupSize {
-					sumAllYes++
-				}
+	// P1 and P2 are just the union and intersection queries, so any other queries are totalled instead of them
+	if *querySpec != "" {
+		var queries []Query
+		for _, spec := range strings.Split(*querySpec, ",") {
+			query, err := ParseQuery(spec)
+			if err != nil {
+				log.Fatal(err)
 			}
-			groupAnswerCounts = make(map[string]int)
-			groupSize = 0
-			continue
+			queries = append(queries, query)
 		}
-		groupSize++
-		for _, char := range strings.Split(input[i], "") {
-			groupAnswerCounts[char]++
-		}
-	}
-	// If there's still content in groupAnswerCounts at this point then it's because the input doesn't contain a trailing empty string
-	if len(groupAnswerCounts) > 0 {
-		for _, count := range groupAnswerCounts {
-			if count == groupSize {
-				sumAllYes++
+		for i, group := range groups {
+			parts := []string{fmt.Sprintf("Group %d | Line %d | People: %d", i+1, group.Line, len(group.People))}
+			for _, query := range queries {
+				answers := query.Aggregate(group)
+				parts = append(parts, fmt.Sprintf("%s: %d [%s]", query.Name, answers.Count(), answers))
 			}
+			log.Println(strings.Join(parts, " | "))
 		}
+		for _, query := range queries {
+			log.Print(fmt.Sprintf("QUERY | %s | Total: %d", query.Name, query.Total(groups)))
+		}
+		return
 	}
-	log.Print(fmt.Sprintf("P2 | Count of group yes answers: %d", sumAllYes))
+
+	// P1: For each group, build up the set of questions anyone answered - the union of everyone's bitsets
+	union, _ := ParseQuery("union")
+	log.Print(fmt.Sprintf("P1 | Count of yes answers: %d", union.Total(groups)))
+
+	// P2: For each group, the questions everyone answered are the intersection of everyone's bitsets
+	intersection, _ := ParseQuery("intersection")
+	log.Print(fmt.Sprintf("P2 | Count of group yes answers: %d", intersection.Total(groups)))
 }
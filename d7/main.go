@@ -2,19 +2,34 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Bag is a simple struct for the Bags in the input
+// Descriptor is the first word of the bag's name and Colour is the rest, which may be empty or several words
 type Bag struct {
 	Descriptor string
 	Colour     string
 }
 
+// String writes the bag the way the rules do, e.g. "shiny gold"
+func (bag Bag) String() string {
+	if bag.Colour == "" {
+		return bag.Descriptor
+	}
+	return bag.Descriptor + " " + bag.Colour
+}
+
 // BagsEqual compares two bags and returns true if their properties match exactly
 func BagsEqual(a, b Bag) bool {
 	// Bags are equal if Descriptor and Colour match
@@ -58,61 +73,791 @@ func BagsInside(outerBag Bag, ruleset map[Bag]map[Bag]int) int {
 	return totalBagsInside
 }
 
+// BagGraph is a ruleset along with a reverse index of which bags directly contain each bag
+// Containment and count queries are memoized, so shared subtrees are only ever walked once
+type BagGraph struct {
+	Contents   map[Bag]map[Bag]int
+	Containers map[Bag]map[Bag]int
+	ancestors  map[Bag]map[Bag]struct{}
+	counts     map[Bag]int
+}
+
+// CycleError is returned when bags contain each other, so that a bag would hold infinitely many bags
+type CycleError struct {
+	Cycle []Bag
+}
+
+func (e CycleError) Error() string {
+	var names []string
+	for _, bag := range e.Cycle {
+		names = append(names, bag.String())
+	}
+	return "bags contain each other: " + strings.Join(names, " -> ")
+}
+
+// NewBagGraph builds the reverse index for a ruleset
+func NewBagGraph(ruleset map[Bag]map[Bag]int) *BagGraph {
+	graph := &BagGraph{
+		Contents:   ruleset,
+		Containers: make(map[Bag]map[Bag]int),
+		ancestors:  make(map[Bag]map[Bag]struct{}),
+		counts:     make(map[Bag]int),
+	}
+	for outerBag, bagsInside := range ruleset {
+		for bagInside, count := range bagsInside {
+			if graph.Containers[bagInside] == nil {
+				graph.Containers[bagInside] = make(map[Bag]int)
+			}
+			graph.Containers[bagInside][outerBag] = count
+		}
+	}
+	return graph
+}
+
+// Ancestors returns every bag that will eventually contain the target
+// Rather than searching down from every outer bag, this searches up from the target once, so it is safe even with cycles
+func (graph *BagGraph) Ancestors(targetBag Bag) map[Bag]struct{} {
+	if found, ok := graph.ancestors[targetBag]; ok {
+		return found
+	}
+	found := make(map[Bag]struct{})
+	queue := []Bag{targetBag}
+	for len(queue) > 0 {
+		bag := queue[0]
+		queue = queue[1:]
+		for container := range graph.Containers[bag] {
+			if _, seen := found[container]; !seen {
+				found[container] = struct{}{}
+				queue = append(queue, container)
+			}
+		}
+	}
+	graph.ancestors[targetBag] = found
+	return found
+}
+
+// Descendants returns every bag that will eventually be inside the outer bag
+func (graph *BagGraph) Descendants(outerBag Bag) map[Bag]struct{} {
+	found := make(map[Bag]struct{})
+	queue := []Bag{outerBag}
+	for len(queue) > 0 {
+		bag := queue[0]
+		queue = queue[1:]
+		for bagInside := range graph.Contents[bag] {
+			if _, seen := found[bagInside]; !seen {
+				found[bagInside] = struct{}{}
+				queue = append(queue, bagInside)
+			}
+		}
+	}
+	return found
+}
+
+// Contains reports whether the outer bag will eventually contain the target bag
+func (graph *BagGraph) Contains(outerBag, targetBag Bag) bool {
+	_, ok := graph.Ancestors(targetBag)[outerBag]
+	return ok
+}
+
+// CountInside returns the total number of bags inside a bag, as BagsInside does
+// A cycle below the bag would make the count infinite, so a CycleError is returned instead
+func (graph *BagGraph) CountInside(outerBag Bag) (int, error) {
+	return graph.countInside(outerBag, nil)
+}
+
+// countInside does the work for CountInside; path is the chain of bags currently being counted, to spot cycles with
+func (graph *BagGraph) countInside(outerBag Bag, path []Bag) (int, error) {
+	if count, ok := graph.counts[outerBag]; ok {
+		return count, nil
+	}
+	for i, bag := range path {
+		if BagsEqual(bag, outerBag) {
+			cycle := append([]Bag{}, path[i:]...)
+			return 0, CycleError{append(cycle, outerBag)}
+		}
+	}
+	path = append(path, outerBag)
+	totalBagsInside := 0
+	for bagInside, countBagInside := range graph.Contents[outerBag] {
+		inside, err := graph.countInside(bagInside, path)
+		if err != nil {
+			return 0, err
+		}
+		totalBagsInside += countBagInside * (1 + inside)
+	}
+	graph.counts[outerBag] = totalBagsInside
+	return totalBagsInside, nil
+}
+
+// FindCycle returns the first cycle found in the ruleset, or nil if there is none
+func (graph *BagGraph) FindCycle() []Bag {
+	// Bags are white until visited, grey while their contents are being searched, and black once done
+	const (
+		white = iota
+		grey
+		black
+	)
+	colours := make(map[Bag]int)
+	var path []Bag
+	var visit func(bag Bag) []Bag
+	visit = func(bag Bag) []Bag {
+		colours[bag] = grey
+		path = append(path, bag)
+		for bagInside := range graph.Contents[bag] {
+			switch colours[bagInside] {
+			case grey:
+				for i := range path {
+					if BagsEqual(path[i], bagInside) {
+						return append(append([]Bag{}, path[i:]...), bagInside)
+					}
+				}
+			case white:
+				if cycle := visit(bagInside); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		colours[bag] = black
+		return nil
+	}
+	// Visit in a fixed order so the same cycle is reported every run
+	outerBags := SortedBags(graph.Contents)
+	for _, bag := range outerBags {
+		if colours[bag] == white {
+			if cycle := visit(bag); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// SortedBags returns the outer bags of a ruleset in alphabetical order
+func SortedBags(ruleset map[Bag]map[Bag]int) []Bag {
+	var bags []Bag
+	for bag := range ruleset {
+		bags = append(bags, bag)
+	}
+	sort.Slice(bags, func(i, j int) bool {
+		if bags[i].Descriptor != bags[j].Descriptor {
+			return bags[i].Descriptor < bags[j].Descriptor
+		}
+		return bags[i].Colour < bags[j].Colour
+	})
+	return bags
+}
+
+// SortedBagSet returns the bags of a set in alphabetical order
+func SortedBagSet(set map[Bag]struct{}) []Bag {
+	ruleset := make(map[Bag]map[Bag]int)
+	for bag := range set {
+		ruleset[bag] = nil
+	}
+	return SortedBags(ruleset)
+}
+
+// WriteDOT writes the ruleset as a Graphviz digraph, with an edge from every bag to each bag inside it labelled with the count
+// If include is not nil, only bags in it (and the edges between them) are written
+// If from is not nil, every edge on a path from that bag down to the target is highlighted, and the target itself always is
+func (graph *BagGraph) WriteDOT(writer io.Writer, include map[Bag]struct{}, targetBag Bag, from *Bag) error {
+	included := func(bag Bag) bool {
+		if include == nil {
+			return true
+		}
+		_, ok := include[bag]
+		return ok
+	}
+
+	// An edge is on a path from -> target if its tail can be reached from the from bag and its head can reach the target
+	var reachable, leadsToTarget map[Bag]struct{}
+	if from != nil {
+		reachable = graph.Descendants(*from)
+		reachable[*from] = struct{}{}
+		leadsToTarget = map[Bag]struct{}{targetBag: {}}
+		for bag := range graph.Ancestors(targetBag) {
+			leadsToTarget[bag] = struct{}{}
+		}
+	}
+	onPath := func(outerBag, bagInside Bag) bool {
+		if from == nil {
+			return false
+		}
+		_, fromOK := reachable[outerBag]
+		_, toOK := leadsToTarget[bagInside]
+		return fromOK && toOK
+	}
+
+	// Every bag appears as a node, including bags that only ever appear inside others
+	nodes := make(map[Bag]struct{})
+	for outerBag, bagsInside := range graph.Contents {
+		nodes[outerBag] = struct{}{}
+		for bagInside := range bagsInside {
+			nodes[bagInside] = struct{}{}
+		}
+	}
+
+	lines := []string{"digraph bags {", "\trankdir=LR;", "\tnode [shape=box];"}
+	for _, bag := range SortedBagSet(nodes) {
+		if !included(bag) {
+			continue
+		}
+		switch {
+		case BagsEqual(bag, targetBag):
+			lines = append(lines, fmt.Sprintf("\t%q [style=filled, fillcolor=gold];", bag.String()))
+		case from != nil && BagsEqual(bag, *from):
+			lines = append(lines, fmt.Sprintf("\t%q [style=filled, fillcolor=lightblue];", bag.String()))
+		default:
+			lines = append(lines, fmt.Sprintf("\t%q;", bag.String()))
+		}
+	}
+	for _, outerBag := range SortedBags(graph.Contents) {
+		if !included(outerBag) {
+			continue
+		}
+		insideSet := make(map[Bag]struct{})
+		for bagInside := range graph.Contents[outerBag] {
+			insideSet[bagInside] = struct{}{}
+		}
+		for _, bagInside := range SortedBagSet(insideSet) {
+			if !included(bagInside) {
+				continue
+			}
+			attributes := fmt.Sprintf("label=\"%d\"", graph.Contents[outerBag][bagInside])
+			if onPath(outerBag, bagInside) {
+				attributes += ", color=red, penwidth=2"
+			}
+			lines = append(lines, fmt.Sprintf("\t%q -> %q [%s];", outerBag.String(), bagInside.String(), attributes))
+		}
+	}
+	lines = append(lines, "}")
+	_, err := io.WriteString(writer, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// BagPath is a chain of bags, each directly inside the one before, with how many of the last bag the first one holds through it
+type BagPath struct {
+	Bags         []Bag
+	Multiplicity int
+}
+
+func (path BagPath) String() string {
+	var names []string
+	for _, bag := range path.Bags {
+		names = append(names, bag.String())
+	}
+	return strings.Join(names, " -> ")
+}
+
+// Paths lists every path from the outer bag down to the target bag
+// A path never visits a bag twice, so this terminates even on a cyclic ruleset
+func (graph *BagGraph) Paths(outerBag, targetBag Bag) []BagPath {
+	var paths []BagPath
+	var walk func(path []Bag, multiplicity int)
+	walk = func(path []Bag, multiplicity int) {
+		bag := path[len(path)-1]
+		if len(path) > 1 && BagsEqual(bag, targetBag) {
+			paths = append(paths, BagPath{append([]Bag{}, path...), multiplicity})
+			return
+		}
+		insideSet := make(map[Bag]struct{})
+		for bagInside := range graph.Contents[bag] {
+			insideSet[bagInside] = struct{}{}
+		}
+		for _, bagInside := range SortedBagSet(insideSet) {
+			visited := false
+			for _, seen := range path[1:] {
+				visited = visited || BagsEqual(seen, bagInside)
+			}
+			if !visited {
+				walk(append(path, bagInside), multiplicity*graph.Contents[bag][bagInside])
+			}
+		}
+	}
+	walk([]Bag{outerBag}, 1)
+	return paths
+}
+
+// MaxDepth returns how many levels of bags are nested inside the outer bag, where an empty bag has a depth of 0
+func (graph *BagGraph) MaxDepth(outerBag Bag) (int, error) {
+	depths := make(map[Bag]int)
+	var depth func(bag Bag, path []Bag) (int, error)
+	depth = func(bag Bag, path []Bag) (int, error) {
+		if known, ok := depths[bag]; ok {
+			return known, nil
+		}
+		for i, seen := range path {
+			if BagsEqual(seen, bag) {
+				return 0, CycleError{append(append([]Bag{}, path[i:]...), bag)}
+			}
+		}
+		deepest := 0
+		for bagInside := range graph.Contents[bag] {
+			inside, err := depth(bagInside, append(path, bag))
+			if err != nil {
+				return 0, err
+			}
+			if inside+1 > deepest {
+				deepest = inside + 1
+			}
+		}
+		depths[bag] = deepest
+		return deepest, nil
+	}
+	return depth(outerBag, nil)
+}
+
+// Leaves lists every bag that holds no other bags
+// Empty bags have no rule of their own in the ruleset, so they are found among the bags inside others
+func (graph *BagGraph) Leaves() []Bag {
+	leaves := make(map[Bag]struct{})
+	for outerBag, bagsInside := range graph.Contents {
+		if len(bagsInside) == 0 {
+			leaves[outerBag] = struct{}{}
+		}
+		for bagInside := range bagsInside {
+			if len(graph.Contents[bagInside]) == 0 {
+				leaves[bagInside] = struct{}{}
+			}
+		}
+	}
+	return SortedBagSet(leaves)
+}
+
+// QueryHelp describes every command Query understands
+var QueryHelp = []string{
+	"containers BAG     bags that directly contain BAG, with how many of it they hold",
+	"contents BAG       bags directly inside BAG, with counts",
+	"ancestors BAG      bags that eventually contain BAG",
+	"count BAG          total number of bags inside BAG",
+	"depth BAG          maximum nesting depth under BAG",
+	"paths BAG to BAG   every path from the first bag down to the second, with its multiplicity",
+	"leaves             bags that contain no other bags",
+	"help               this list",
+	"quit               stop reading queries",
+}
+
+// Query answers a single command about the ruleset, returning the lines of the answer
+func (graph *BagGraph) Query(command string) ([]string, error) {
+	words := strings.Fields(command)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	verb, argument := words[0], strings.Join(words[1:], " ")
+	bagLines := func(bags []Bag) []string {
+		lines := []string{fmt.Sprintf("%d bags", len(bags))}
+		for _, bag := range bags {
+			lines = append(lines, bag.String())
+		}
+		return lines
+	}
+	countLines := func(counts map[Bag]int) []string {
+		set := make(map[Bag]struct{})
+		for bag := range counts {
+			set[bag] = struct{}{}
+		}
+		lines := []string{fmt.Sprintf("%d bags", len(set))}
+		for _, bag := range SortedBagSet(set) {
+			lines = append(lines, fmt.Sprintf("%d %s", counts[bag], bag))
+		}
+		return lines
+	}
+
+	switch verb {
+	case "help":
+		return QueryHelp, nil
+	case "leaves":
+		return bagLines(graph.Leaves()), nil
+	case "paths":
+		split := strings.Split(" "+argument+" ", " to ")
+		if len(split) != 2 {
+			return nil, errors.New("paths needs two bags, e.g. paths light red to shiny gold")
+		}
+		outerBag, err := ParseBag(split[0])
+		if err != nil {
+			return nil, err
+		}
+		targetBag, err := ParseBag(split[1])
+		if err != nil {
+			return nil, err
+		}
+		paths := graph.Paths(outerBag, targetBag)
+		total := 0
+		var lines []string
+		for _, path := range paths {
+			total += path.Multiplicity
+			lines = append(lines, fmt.Sprintf("%d x %s", path.Multiplicity, path))
+		}
+		return append([]string{fmt.Sprintf("%d paths holding %d bags in total", len(paths), total)}, lines...), nil
+	case "containers", "contents", "ancestors", "count", "depth":
+	default:
+		return nil, fmt.Errorf("unknown command %q, try help", verb)
+	}
+
+	// Everything else is about a single bag
+	if argument == "" {
+		return nil, fmt.Errorf("%s needs a bag, e.g. %s shiny gold", verb, verb)
+	}
+	bag, err := ParseBag(argument)
+	if err != nil {
+		return nil, err
+	}
+	switch verb {
+	case "containers":
+		return countLines(graph.Containers[bag]), nil
+	case "contents":
+		return countLines(graph.Contents[bag]), nil
+	case "ancestors":
+		return bagLines(SortedBagSet(graph.Ancestors(bag))), nil
+	case "count":
+		count, err := graph.CountInside(bag)
+		if err != nil {
+			return nil, err
+		}
+		return []string{strconv.Itoa(count)}, nil
+	default:
+		depth, err := graph.MaxDepth(bag)
+		if err != nil {
+			return nil, err
+		}
+		return []string{strconv.Itoa(depth)}, nil
+	}
+}
+
+// RunQueries answers commands from the reader one line at a time until it runs out or a line says quit
+// Answers and errors both go to the writer, so a misspelt colour is reported next to the query that used it
+func RunQueries(graph *BagGraph, reader io.Reader, writer io.Writer, prompt bool) error {
+	scanner := bufio.NewScanner(reader)
+	for {
+		if prompt {
+			fmt.Fprint(writer, "bags> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		command := strings.TrimSpace(scanner.Text())
+		if command == "quit" || command == "exit" {
+			break
+		}
+		lines, err := graph.Query(command)
+		if err != nil {
+			lines = []string{"error: " + err.Error()}
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(writer, line); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// ParseBag reads a bag name such as "shiny gold"
+func ParseBag(name string) (Bag, error) {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return Bag{}, errors.New("bag name is empty")
+	}
+	return Bag{words[0], strings.Join(words[1:], " ")}, nil
+}
+
+// RuleError is a rule that doesn't follow the grammar, pointing at where it went wrong
+// Kind says which part of the grammar was broken, e.g. a plural or a comma, without having to read Reason
+type RuleError struct {
+	Line   int
+	Column int
+	Kind   string
+	Reason string
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Reason)
+}
+
+// The kinds of RuleError
+const (
+	KindExpectedWord  string = "expected word"
+	KindEmptyBag      string = "empty bag name"
+	KindPlural        string = "wrong plural"
+	KindPunctuation   string = "punctuation"
+	KindBadCount      string = "bad count"
+	KindDuplicateBag  string = "duplicate bag"
+	KindDuplicateRule string = "duplicate rule"
+)
+
+// ruleScanner walks through a single rule, keeping track of the column for errors
+type ruleScanner struct {
+	line     int
+	text     string
+	position int
+}
+
+func (scanner *ruleScanner) fail(column int, kind, format string, args ...interface{}) *RuleError {
+	return &RuleError{Line: scanner.line, Column: column + 1, Kind: kind, Reason: fmt.Sprintf(format, args...)}
+}
+
+// literal consumes the text if the rule continues with it
+func (scanner *ruleScanner) literal(text string) bool {
+	if strings.HasPrefix(scanner.text[scanner.position:], text) {
+		scanner.position += len(text)
+		return true
+	}
+	return false
+}
+
+// expect consumes the text, or fails with a punctuation error showing what was there instead
+func (scanner *ruleScanner) expect(text string) error {
+	if scanner.literal(text) {
+		return nil
+	}
+	found := scanner.text[scanner.position:]
+	if len(found) > len(text) {
+		found = found[:len(text)]
+	}
+	return scanner.fail(scanner.position, KindPunctuation, "expected %q, found %q", text, found)
+}
+
+// word consumes a run of letters, returning the empty string if there are none
+func (scanner *ruleScanner) word() string {
+	start := scanner.position
+	for scanner.position < len(scanner.text) {
+		letter, size := utf8.DecodeRuneInString(scanner.text[scanner.position:])
+		if !unicode.IsLetter(letter) {
+			break
+		}
+		scanner.position += size
+	}
+	return scanner.text[start:scanner.position]
+}
+
+// bag reads the words of a bag's name up to and including "bag" or "bags", which is returned as the noun
+func (scanner *ruleScanner) bag() (Bag, string, error) {
+	start := scanner.position
+	var words []string
+	for {
+		column := scanner.position
+		word := scanner.word()
+		if word == "" {
+			return Bag{}, "", scanner.fail(column, KindExpectedWord, "expected a word of a bag's name")
+		}
+		if word == "bag" || word == "bags" {
+			if len(words) == 0 {
+				return Bag{}, "", scanner.fail(start, KindEmptyBag, "%q has no name before it", word)
+			}
+			return Bag{words[0], strings.Join(words[1:], " ")}, word, nil
+		}
+		words = append(words, word)
+		if err := scanner.expect(" "); err != nil {
+			return Bag{}, "", err
+		}
+	}
+}
+
+// ParseRule reads a single rule against the grammar
+//
+//	rule     = name " bags contain " contents "."
+//	contents = "no other bags" | item { ", " item }
+//	item     = count " " name ( " bag" | " bags" )
+//	name     = word { " " word }
+//
+// The noun after each item has to agree with its count, and the count can't be 0 or start with 0
+// A colour may be any number of words, but none of them can be "bag" or "bags"
+func ParseRule(lineNumber int, rule string) (Bag, map[Bag]int, error) {
+	scanner := &ruleScanner{line: lineNumber, text: rule}
+	outerBag, noun, err := scanner.bag()
+	if err != nil {
+		return Bag{}, nil, err
+	}
+	if noun != "bags" {
+		return Bag{}, nil, scanner.fail(scanner.position-len(noun), KindPlural, "a rule starts with %q, so it should say bags", outerBag)
+	}
+	if err := scanner.expect(" contain "); err != nil {
+		return Bag{}, nil, err
+	}
+
+	bagsInside := make(map[Bag]int)
+	if scanner.literal("no other bags") {
+		if err := scanner.expect("."); err != nil {
+			return Bag{}, nil, err
+		}
+	} else {
+		for {
+			column := scanner.position
+			start := scanner.position
+			for scanner.position < len(rule) && unicode.IsDigit(rune(rule[scanner.position])) {
+				scanner.position++
+			}
+			if start == scanner.position {
+				return Bag{}, nil, scanner.fail(column, KindBadCount, "expected a count or \"no other bags\"")
+			}
+			count, err := strconv.Atoi(rule[start:scanner.position])
+			if err != nil || count == 0 || rule[start] == '0' {
+				return Bag{}, nil, scanner.fail(column, KindBadCount, "count %s should be a positive number without leading zeros", rule[start:scanner.position])
+			}
+			if err := scanner.expect(" "); err != nil {
+				return Bag{}, nil, err
+			}
+
+			column = scanner.position
+			bagInside, noun, err := scanner.bag()
+			if err != nil {
+				return Bag{}, nil, err
+			}
+			if count == 1 && noun != "bag" || count > 1 && noun != "bags" {
+				return Bag{}, nil, scanner.fail(scanner.position-len(noun), KindPlural, "%d %s should not say %s", count, bagInside, noun)
+			}
+			if _, ok := bagsInside[bagInside]; ok {
+				return Bag{}, nil, scanner.fail(column, KindDuplicateBag, "%s is listed twice", bagInside)
+			}
+			bagsInside[bagInside] = count
+
+			if scanner.literal(".") {
+				break
+			}
+			if !scanner.literal(", ") {
+				return Bag{}, nil, scanner.fail(scanner.position, KindPunctuation, "expected \", \" or \".\" after %d %s %s", count, bagInside, noun)
+			}
+		}
+	}
+	if scanner.position != len(rule) {
+		return Bag{}, nil, scanner.fail(scanner.position, KindPunctuation, "unexpected %q after the end of the rule", rule[scanner.position:])
+	}
+	return outerBag, bagsInside, nil
+}
+
+// ParseRules reads one rule per line, stopping at the first that doesn't parse
+// Blank lines are skipped, a trailing \r is dropped so CRLF files parse, and bags that hold no other bags get no entry in the ruleset
+func ParseRules(reader io.Reader) (map[Bag]map[Bag]int, error) {
+	ruleset := make(map[Bag]map[Bag]int)
+	ruleLines := make(map[Bag]int)
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		outerBag, bagsInside, err := ParseRule(lineNumber, text)
+		if err != nil {
+			return nil, err
+		}
+		if line, ok := ruleLines[outerBag]; ok {
+			return nil, &RuleError{Line: lineNumber, Column: 1, Kind: KindDuplicateRule, Reason: fmt.Sprintf("%s already has a rule on line %d", outerBag, line)}
+		}
+		ruleLines[outerBag] = lineNumber
+		if len(bagsInside) > 0 {
+			ruleset[outerBag] = bagsInside
+		}
+	}
+	return ruleset, scanner.Err()
+}
+
 func main() {
+	target := flag.String("target", "shiny gold", "the bag to look for and count inside")
+	dot := flag.String("dot", "", "write the rules to stdout as Graphviz DOT instead of solving the puzzle: all, ancestors or descendants of -dot-bag")
+	dotBag := flag.String("dot-bag", "", "the bag whose ancestors or descendants are written; defaults to -target")
+	highlightFrom := flag.String("highlight-from", "", "highlight every path in the DOT output from this bag down to -target")
+	query := flag.String("query", "", "answer a single query about the rules instead of solving the puzzle; -query help lists them")
+	repl := flag.Bool("repl", false, "answer queries about the rules read from stdin instead of solving the puzzle")
+	flag.Parse()
+	targetBag, err := ParseBag(*target)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Reader
 	path := "./input.txt"
 	buf, err := os.Open(path)
 	if err != nil {
 		log.Fatal(err)
 	}
-	scanner := bufio.NewScanner(buf)
-
-	// Retrieve input
-	var input []string
-	for scanner.Scan() {
-		input = append(input, scanner.Text())
-	}
 
 	// Every rule exists only on one line, thankfully.
-	// In regex form, (\w+ \w+) bags contain (\d+ \w+ \w+ bags?(, )?)+\.
-	// Where shit gets real is that each rule is only one level deep - but **we have to go deeper.**
-	// oui, je regrette tout
-	// For now, just compile the ruleset one level deep. It should be good enough.
-	ruleset := make(map[Bag]map[Bag]int)
-	reBagsIn := regexp.MustCompile("\\w+ \\w+ \\w+") // The punctuation after {quantity} {descriptor} {colour} can be ignored
-	for _, rule := range input {
-		ruleSplit := strings.Split(rule, " bags contain ")
-
-		bagOuter := Bag{strings.Split(ruleSplit[0], " ")[0], strings.Split(ruleSplit[0], " ")[1]}
+	// Each rule is only one level deep - but **we have to go deeper.**
+	ruleset, err := ParseRules(buf)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		bagsInner := reBagsIn.FindAllString(ruleSplit[1], -1)
+	// Questions about the rules can name any bag, so the two counts for -target are only worked out when nothing else was asked
+	if *query != "" {
+		lines, err := NewBagGraph(ruleset).Query(*query)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return
+	}
+	if *repl {
+		if err := RunQueries(NewBagGraph(ruleset), os.Stdin, os.Stdout, true); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-		if bagsInner[0] != "no other bags" {
-			bagsInnerMap := make(map[Bag]int)
-			for _, bag := range bagsInner {
-				bagInner := Bag{strings.Split(bag, " ")[1], strings.Split(bag, " ")[2]}
-				bagInnerCount, err := strconv.Atoi(strings.Split(bag, " ")[0])
-				if err != nil {
-					log.Fatal(err)
-				}
-				bagsInnerMap[bagInner] = bagInnerCount
+	// The DOT graph goes to stdout to be piped into Graphviz, so nothing else may be printed after it
+	if *dot != "" {
+		graph := NewBagGraph(ruleset)
+		scopeBag := targetBag
+		if *dotBag != "" {
+			if scopeBag, err = ParseBag(*dotBag); err != nil {
+				log.Fatal(err)
+			}
+		}
+		// Limiting to ancestors or descendants keeps the chosen bag itself, and the target if a path to it is highlighted
+		var include map[Bag]struct{}
+		switch *dot {
+		case "all":
+		case "ancestors":
+			include = make(map[Bag]struct{})
+			for bag := range graph.Ancestors(scopeBag) {
+				include[bag] = struct{}{}
+			}
+		case "descendants":
+			include = graph.Descendants(scopeBag)
+		default:
+			log.Fatal("DOT scope should be all, ancestors or descendants, it was ", *dot)
+		}
+		if include != nil {
+			include[scopeBag] = struct{}{}
+		}
+		var from *Bag
+		if *highlightFrom != "" {
+			fromBag, err := ParseBag(*highlightFrom)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !graph.Contains(fromBag, targetBag) {
+				log.Println("WARN |", fromBag, "will never contain a", targetBag, "bag, so there is no path to highlight")
+			}
+			from = &fromBag
+			if include != nil {
+				include[targetBag] = struct{}{}
 			}
-			ruleset[bagOuter] = bagsInnerMap
 		}
+		if err := graph.WriteDOT(os.Stdout, include, targetBag, from); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	// P1: Now the deep search has to happen.
 	// For every outer bag in a rule, pull up the rules for the inner bags, if they exist.
 	// If for a given outer bag there is no rule, then it is time to end recursion.
 	// Alternatively, once a bag of Descriptor shiny and Colour gold is found, cease recursion and increment the counter.
+	// WillContainBag does exactly that, but recomputes shared subtrees and never returns on a cyclic ruleset.
+	// BagGraph instead searches up from the target bag once, through the reverse index.
+	graph := NewBagGraph(ruleset)
 	matches := 0
-	targetBag := Bag{"shiny", "gold"}
 	for outerBag := range ruleset {
-		if WillContainBag(outerBag, targetBag, ruleset) {
+		if graph.Contains(outerBag, targetBag) {
 			matches++
 		}
 	}
-	log.Println("P1 | Bags containing a", targetBag.Descriptor, targetBag.Colour, "bag:", matches)
+	log.Println("P1 | Bags containing a", targetBag, "bag:", matches)
 
 	// P2: In this case there isn't a wide search but a deep search.
 	// There is a single rule for shiny gold bags, but (just looking at the rule for it) there are a significant number of bags for those.
@@ -128,5 +873,13 @@ func main() {
 	// Bag B returns 2*(D+1) = 2*1 = 2
 	// Bag A returns 1*(B+1)+1*(C+1) = 1*(2+1)+1*(0+1) = 1*3+1*1 = 3+1 = 4
 	// The +1 when calculating bags inside is to add the containing bag to the contained bags.
-	log.Println("P2 | Bags inside a", targetBag.Descriptor, targetBag.Colour, "bag:", BagsInside(targetBag, ruleset))
+	// BagGraph memoizes the count for every bag, and stops with the offending cycle instead of recursing forever.
+	if cycle := graph.FindCycle(); cycle != nil {
+		log.Println("WARN | Ruleset has a cycle:", CycleError{cycle})
+	}
+	bagsInside, err := graph.CountInside(targetBag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("P2 | Bags inside a", targetBag, "bag:", bagsInside)
 }
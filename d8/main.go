@@ -2,102 +2,1320 @@ package main
 
 import (
 	"bufio"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+// Instruction is a single line of boot code, e.g. "jmp -4" is Instruction{Op: "jmp", Arg: -4}
+// Line is where it was read from, or 0 for an instruction that didn't come from a file
+type Instruction struct {
+	Op   string
+	Arg  int
+	Line int
+}
+
+// String writes the instruction the way the boot code does, always with a sign on the argument
+func (instruction Instruction) String() string {
+	return fmt.Sprintf("%s %+d", instruction.Op, instruction.Arg)
+}
+
+// ParseInstruction reads a single line of boot code
+// Unknown operations are kept, so that the machine can halt on them rather than refusing the whole program
+func ParseInstruction(line string) (Instruction, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return Instruction{}, fmt.Errorf("instruction %q should be an operation and an argument", line)
+	}
+	if fields[1][0] != '+' && fields[1][0] != '-' {
+		return Instruction{}, fmt.Errorf("argument %q should have a sign", fields[1])
+	}
+	arg, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Instruction{}, fmt.Errorf("argument %q is not a number", fields[1])
+	}
+	return Instruction{Op: fields[0], Arg: arg}, nil
+}
+
+// ParseProgram reads one instruction per line, stopping at the first that doesn't parse
+// Blank lines are skipped, but each instruction remembers its line so that everything reported still points at the file
+func ParseProgram(reader io.Reader) ([]Instruction, error) {
+	var program []Instruction
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		instruction, err := ParseInstruction(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		instruction.Line = lineNumber
+		program = append(program, instruction)
+	}
+	return program, scanner.Err()
+}
+
+// LineOf returns the line of the boot code that the instruction at index came from
+// Instructions without a line count from 1, and an index outside the program carries on from the nearest end of it
+func LineOf(program []Instruction, index int) int {
+	switch {
+	case len(program) == 0:
+		return index + 1
+	case index < 0:
+		return LineOf(program, 0) + index
+	case index >= len(program):
+		return LineOf(program, len(program)-1) + index - len(program) + 1
+	case program[index].Line == 0:
+		return index + 1
+	}
+	return program[index].Line
+}
+
+// HaltState is why a machine stopped, or Running if it hasn't
+type HaltState int
+
+// The states a machine can be in
+const (
+	Running HaltState = iota
+	Terminated
+	LoopDetected
+	OutOfBounds
+	InvalidOpcode
+)
+
+func (state HaltState) String() string {
+	switch state {
+	case Running:
+		return "running"
+	case Terminated:
+		return "terminated"
+	case LoopDetected:
+		return "loop detected"
+	case OutOfBounds:
+		return "out of bounds"
+	case InvalidOpcode:
+		return "invalid opcode"
+	}
+	return "unknown state " + strconv.Itoa(int(state))
+}
+
+// Operation carries out one instruction's effect on the machine, apart from moving the program counter on
+type Operation func(machine *Machine, arg int)
+
+// Opcode is an operation together with where it sends execution
+// Next is the index executed after the instruction at index, which lets the analysis follow control flow without running anything
+// Jump marks an operation whose argument is an offset to another instruction, so it ends a basic block and its target gets a label
+type Opcode struct {
+	Execute Operation
+	Next    func(index, arg int) int
+	Jump    bool
+}
+
+// fallThrough is the Next of every operation that carries on with the following instruction
+func fallThrough(index, arg int) int {
+	return index + 1
+}
+
+// Opcodes is the instruction set every new machine starts with and the analysis follows - add to it to teach both a new operation
+var Opcodes = map[string]Opcode{
+	"acc": {
+		Execute: func(machine *Machine, arg int) {
+			machine.Accumulator += arg
+		},
+		Next: fallThrough,
+	},
+	"jmp": {
+		Execute: func(machine *Machine, arg int) {},
+		Next: func(index, arg int) int {
+			return index + arg
+		},
+		Jump: true,
+	},
+	"nop": {
+		Execute: func(machine *Machine, arg int) {},
+		Next:    fallThrough,
+	},
+}
+
+// Machine is the handheld console running a program
+// PC is the index of the next instruction in Program and Steps is how many instructions have been executed
+type Machine struct {
+	Program     []Instruction
+	Opcodes     map[string]Opcode
+	PC          int
+	Accumulator int
+	Steps       int
+	State       HaltState
+	executed    []bool
+}
+
+// NewMachine loads a program into a machine with its own copy of the instruction set
+func NewMachine(program []Instruction) *Machine {
+	opcodes := make(map[string]Opcode)
+	for op, opcode := range Opcodes {
+		opcodes[op] = opcode
+	}
+	return &Machine{
+		Program:  program,
+		Opcodes:  opcodes,
+		executed: make([]bool, len(program)),
+	}
+}
+
+// Step executes the next instruction, unless the machine halts first
+// The checks happen before executing, so on a loop the accumulator holds its value from before the repeated instruction
+func (machine *Machine) Step() HaltState {
+	if machine.State != Running {
+		return machine.State
+	}
+	switch {
+	case machine.PC == len(machine.Program):
+		machine.State = Terminated
+	case machine.PC < 0 || machine.PC > len(machine.Program):
+		machine.State = OutOfBounds
+	case machine.executed[machine.PC]:
+		machine.State = LoopDetected
+	default:
+		instruction := machine.Program[machine.PC]
+		opcode, ok := machine.Opcodes[instruction.Op]
+		if !ok {
+			machine.State = InvalidOpcode
+			break
+		}
+		machine.executed[machine.PC] = true
+		opcode.Execute(machine, instruction.Arg)
+		machine.PC = opcode.Next(machine.PC, instruction.Arg)
+		machine.Steps++
+	}
+	return machine.State
+}
+
+// Run steps the machine until it halts
+func (machine *Machine) Run() HaltState {
+	for machine.Step() == Running {
+	}
+	return machine.State
+}
+
 // Execute executes the program - if it is an infinite loop, it performs one complete period and quits
-func Execute(instructions map[int]string) (int, bool) {
-	accumulator := 0
-	completed := false
-	current := 1
-	executed := make(map[int]struct{})
-	reNum := regexp.MustCompile("[0-9]+")
-
-	// Start with the first instruction
-	for true {
-		// Before proceeding any further, check if the upcoming line is empty string - if it is, the program is done
-		if instructions[current] == "" {
-			completed = true
+func Execute(program []Instruction) (int, bool) {
+	machine := NewMachine(program)
+	state := machine.Run()
+	return machine.Accumulator, state == Terminated
+}
+
+// TraceEntry is a single executed instruction, with the accumulator either side of it
+// Index is the instruction's place in the program and Line is its line in the boot code
+type TraceEntry struct {
+	Step        int
+	Index       int
+	Line        int
+	Instruction Instruction
+	Before      int
+	After       int
+}
+
+func (entry TraceEntry) String() string {
+	return fmt.Sprintf("%6d | line %4d | %-8s | acc %d -> %d", entry.Step, entry.Line, entry.Instruction, entry.Before, entry.After)
+}
+
+// TraceStep steps the machine, reporting the instruction it executed, or false if it halted instead
+func TraceStep(machine *Machine) (TraceEntry, bool) {
+	entry := TraceEntry{Step: machine.Steps + 1, Index: machine.PC, Line: LineOf(machine.Program, machine.PC), Before: machine.Accumulator}
+	if machine.PC >= 0 && machine.PC < len(machine.Program) {
+		entry.Instruction = machine.Program[machine.PC]
+	}
+	steps := machine.Steps
+	machine.Step()
+	entry.After = machine.Accumulator
+	return entry, machine.Steps > steps
+}
+
+// ExecuteTrace is Execute, writing one line per executed instruction and a final line saying why the program halted
+func ExecuteTrace(program []Instruction, writer io.Writer) (int, bool, error) {
+	machine := NewMachine(program)
+	for {
+		entry, executed := TraceStep(machine)
+		if !executed {
 			break
 		}
+		if _, err := fmt.Fprintln(writer, entry); err != nil {
+			return 0, false, err
+		}
+	}
+	_, err := fmt.Fprintf(writer, "halted at line %d: %s\n", LineOf(machine.Program, machine.PC), machine.State)
+	return machine.Accumulator, machine.State == Terminated, err
+}
+
+// Debugger runs a machine a step at a time, stopping at breakpoints and keeping a backtrace of what it executed
+// Breakpoints stop before the instruction runs, and watches stop after the accumulator changes
+type Debugger struct {
+	Machine     *Machine
+	Lines       map[int]struct{}
+	Ops         map[string]struct{}
+	Watch       bool
+	WatchValue  *int
+	History     []TraceEntry
+	HistorySize int
+}
 
-		// Every instruction can be split by space
-		cmd := strings.Split(instructions[current], " ")[0]
-		valStr := strings.Split(instructions[current], " ")[1]
-		val, err := strconv.Atoi(reNum.FindString(valStr))
+// NewDebugger loads a program into a fresh machine, remembering up to historySize executed instructions
+func NewDebugger(program []Instruction, historySize int) *Debugger {
+	return &Debugger{
+		Machine:     NewMachine(program),
+		Lines:       make(map[int]struct{}),
+		Ops:         make(map[string]struct{}),
+		HistorySize: historySize,
+	}
+}
+
+// DebuggerHelp is printed by the help command, with the short forms s, c and bt also accepted
+var DebuggerHelp = []string{
+	"step [N]           execute the next N instructions, default 1, stopping early at a watch",
+	"continue           run until a breakpoint, a watch or the program halts",
+	"break line N       stop before executing line N",
+	"break op OP        stop before executing any OP instruction",
+	"delete line N      remove a line breakpoint",
+	"delete op OP       remove an opcode breakpoint",
+	"watch [N]          stop whenever the accumulator changes, or only when it becomes N",
+	"unwatch            remove the watch",
+	"backtrace [N]      the last N executed instructions, default 10",
+	"info               the machine's registers and breakpoints",
+	"reset              reload the program, keeping breakpoints and the watch",
+	"help               this list",
+	"quit               leave the debugger",
+}
+
+// step executes one instruction, recording it in the history, and reports whether a watch was triggered
+func (debugger *Debugger) step() (TraceEntry, bool, bool) {
+	entry, executed := TraceStep(debugger.Machine)
+	if !executed {
+		return entry, false, false
+	}
+	debugger.History = append(debugger.History, entry)
+	if len(debugger.History) > debugger.HistorySize {
+		debugger.History = debugger.History[len(debugger.History)-debugger.HistorySize:]
+	}
+	watched := debugger.Watch && entry.Before != entry.After
+	if debugger.WatchValue != nil {
+		watched = watched && entry.After == *debugger.WatchValue
+	}
+	return entry, true, watched
+}
+
+// atBreakpoint reports the breakpoint in front of the machine, if there is one
+func (debugger *Debugger) atBreakpoint() (string, bool) {
+	machine := debugger.Machine
+	if machine.State != Running || machine.PC < 0 || machine.PC >= len(machine.Program) {
+		return "", false
+	}
+	if _, ok := debugger.Lines[LineOf(machine.Program, machine.PC)]; ok {
+		return fmt.Sprintf("breakpoint at line %d", LineOf(machine.Program, machine.PC)), true
+	}
+	if _, ok := debugger.Ops[machine.Program[machine.PC].Op]; ok {
+		return fmt.Sprintf("breakpoint on %s at line %d", machine.Program[machine.PC].Op, LineOf(machine.Program, machine.PC)), true
+	}
+	return "", false
+}
+
+// where describes the machine's position, either the next instruction or why it halted
+func (debugger *Debugger) where() string {
+	machine := debugger.Machine
+	if machine.State != Running {
+		return fmt.Sprintf("halted at line %d: %s, acc %d", LineOf(machine.Program, machine.PC), machine.State, machine.Accumulator)
+	}
+	next := "past the end of the program"
+	if machine.PC >= 0 && machine.PC < len(machine.Program) {
+		next = machine.Program[machine.PC].String()
+	}
+	return fmt.Sprintf("next line %d: %s, acc %d", LineOf(machine.Program, machine.PC), next, machine.Accumulator)
+}
+
+// Command carries out a single debugger command, returning the lines to show
+func (debugger *Debugger) Command(command string) ([]string, error) {
+	words := strings.Fields(command)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	number := func(index int, fallback int) (int, error) {
+		if len(words) <= index {
+			return fallback, nil
+		}
+		n, err := strconv.Atoi(words[index])
 		if err != nil {
-			log.Fatal(err)
+			return 0, fmt.Errorf("%s expects a number, not %q", words[0], words[index])
+		}
+		return n, nil
+	}
+
+	switch words[0] {
+	case "help":
+		return DebuggerHelp, nil
+	case "step", "s":
+		count, err := number(1, 1)
+		if err != nil {
+			return nil, err
+		}
+		var lines []string
+		for i := 0; i < count; i++ {
+			entry, executed, watched := debugger.step()
+			if !executed {
+				break
+			}
+			lines = append(lines, entry.String())
+			if watched {
+				lines = append(lines, "watch triggered")
+				break
+			}
+		}
+		return append(lines, debugger.where()), nil
+	case "continue", "c":
+		var lines []string
+		// The first instruction runs regardless, so continuing from a breakpoint doesn't stop at it again
+		for first := true; ; first = false {
+			if reason, ok := debugger.atBreakpoint(); ok && !first {
+				lines = append(lines, reason)
+				break
+			}
+			entry, executed, watched := debugger.step()
+			if !executed {
+				break
+			}
+			if watched {
+				lines = append(lines, entry.String(), "watch triggered")
+				break
+			}
+		}
+		return append(lines, debugger.where()), nil
+	case "break", "delete":
+		if len(words) != 3 {
+			return nil, fmt.Errorf("%s needs line N or op OP", words[0])
+		}
+		switch words[1] {
+		case "line":
+			line, err := number(2, 0)
+			if err != nil {
+				return nil, err
+			}
+			if words[0] == "break" {
+				debugger.Lines[line] = struct{}{}
+			} else {
+				delete(debugger.Lines, line)
+			}
+		case "op":
+			if words[0] == "break" {
+				debugger.Ops[words[2]] = struct{}{}
+			} else {
+				delete(debugger.Ops, words[2])
+			}
+		default:
+			return nil, fmt.Errorf("%s needs line N or op OP", words[0])
+		}
+		return nil, nil
+	case "watch":
+		debugger.Watch = true
+		debugger.WatchValue = nil
+		if len(words) > 1 {
+			value, err := number(1, 0)
+			if err != nil {
+				return nil, err
+			}
+			debugger.WatchValue = &value
+		}
+		return nil, nil
+	case "unwatch":
+		debugger.Watch = false
+		debugger.WatchValue = nil
+		return nil, nil
+	case "backtrace", "bt":
+		count, err := number(1, 10)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("backtrace can't show %d instructions", count)
+		}
+		history := debugger.History
+		if count < len(history) {
+			history = history[len(history)-count:]
+		}
+		var lines []string
+		for _, entry := range history {
+			lines = append(lines, entry.String())
+		}
+		return lines, nil
+	case "info":
+		var lines []int
+		for line := range debugger.Lines {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		var ops []string
+		for op := range debugger.Ops {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		watch := "off"
+		if debugger.WatchValue != nil {
+			watch = "acc = " + strconv.Itoa(*debugger.WatchValue)
+		} else if debugger.Watch {
+			watch = "any change"
+		}
+		return []string{
+			debugger.where(),
+			fmt.Sprintf("steps %d", debugger.Machine.Steps),
+			fmt.Sprintf("line breakpoints %v", lines),
+			fmt.Sprintf("opcode breakpoints %v", ops),
+			"watch " + watch,
+		}, nil
+	case "reset":
+		debugger.Machine = NewMachine(debugger.Machine.Program)
+		debugger.History = nil
+		return []string{debugger.where()}, nil
+	}
+	return nil, fmt.Errorf("unknown command %q, try help", words[0])
+}
+
+// RunDebugger reads debugger commands one line at a time until it runs out or a line says quit
+// An error leaves the machine exactly where it stopped, so a mistyped command never costs the steps already taken
+func RunDebugger(debugger *Debugger, reader io.Reader, writer io.Writer, prompt bool) error {
+	scanner := bufio.NewScanner(reader)
+	if _, err := fmt.Fprintln(writer, debugger.where()); err != nil {
+		return err
+	}
+	for {
+		if prompt {
+			fmt.Fprint(writer, "(d8) ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		command := strings.TrimSpace(scanner.Text())
+		if command == "quit" || command == "q" {
+			break
+		}
+		lines, err := debugger.Command(command)
+		if err != nil {
+			lines = []string{"error: " + err.Error()}
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(writer, line); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// flip swaps a jmp for a nop and the other way around, reporting false for any other operation
+func flip(instruction *Instruction) bool {
+	switch instruction.Op {
+	case "jmp":
+		instruction.Op = "nop"
+	case "nop":
+		instruction.Op = "jmp"
+	default:
+		return false
+	}
+	return true
+}
+
+// Successor returns the index executed after the instruction at index, or -1 if the machine halts there instead
+// Only operations in Opcodes are understood, so any other operation counts as halting on an invalid opcode
+// A jump to just past the end is len(program), which is where the program terminates
+func Successor(program []Instruction, index int) int {
+	next, ok := target(program[index], index)
+	if !ok || next < 0 || next > len(program) {
+		return -1
+	}
+	return next
+}
+
+// target is where the instruction at index sends execution, which may be outside the program, or false for an operation that isn't understood
+func target(instruction Instruction, index int) (int, bool) {
+	opcode, ok := Opcodes[instruction.Op]
+	if !ok {
+		return 0, false
+	}
+	return opcode.Next(index, instruction.Arg), true
+}
+
+// ReachesEnd reports for every index whether execution starting there terminates, with one extra entry for the end itself
+// It walks the control-flow graph backwards from the end once, so it is linear in the length of the program
+func ReachesEnd(program []Instruction) []bool {
+	predecessors := make([][]int, len(program)+1)
+	for index := range program {
+		if next := Successor(program, index); next >= 0 {
+			predecessors[next] = append(predecessors[next], index)
+		}
+	}
+	reaches := make([]bool, len(program)+1)
+	reaches[len(program)] = true
+	queue := []int{len(program)}
+	for len(queue) > 0 {
+		index := queue[0]
+		queue = queue[1:]
+		for _, predecessor := range predecessors[index] {
+			if !reaches[predecessor] {
+				reaches[predecessor] = true
+				queue = append(queue, predecessor)
+			}
+		}
+	}
+	return reaches
+}
+
+// ExecutionPath returns the indices the unmodified program executes, in order, and why it halted
+func ExecutionPath(program []Instruction) ([]int, HaltState) {
+	var path []int
+	machine := NewMachine(program)
+	for {
+		entry, executed := TraceStep(machine)
+		if !executed {
+			return path, machine.State
+		}
+		path = append(path, entry.Index)
+	}
+}
+
+// Repair is a set of flipped instructions that makes the program terminate
+// Lines are 1-based and Accumulator is the value once the repaired program terminates
+type Repair struct {
+	Lines       []int
+	Accumulator int
+}
+
+func (repair Repair) String() string {
+	var lines []string
+	for _, line := range repair.Lines {
+		lines = append(lines, strconv.Itoa(line))
+	}
+	return fmt.Sprintf("line %s | accumulator %d", strings.Join(lines, " and line "), repair.Accumulator)
+}
+
+// flipped returns a copy of the program with the instructions at the given indices flipped
+func flipped(program []Instruction, indices ...int) []Instruction {
+	repaired := append([]Instruction{}, program...)
+	for _, index := range indices {
+		flip(&repaired[index])
+	}
+	return repaired
+}
+
+// repairsAfter finds the single flips that make the program terminate, among the instructions it executes
+// Flipping an instruction the program never reaches changes nothing, and until the flipped instruction the program runs as before
+// So a flip works if the instruction is on the execution path and its new successor reaches the end
+func repairsAfter(program []Instruction, path []int, reaches []bool, first bool) []int {
+	var indices []int
+	for _, index := range path {
+		instruction := program[index]
+		if !flip(&instruction) {
+			continue
+		}
+		next, ok := target(instruction, index)
+		if ok && next >= 0 && next <= len(program) && reaches[next] {
+			indices = append(indices, index)
+			if first {
+				break
+			}
+		}
+	}
+	return indices
+}
+
+// FindRepair finds the first single flip along the execution path that makes a looping program terminate
+// Each instruction is looked at a constant number of times, so unlike flipping and rerunning every jmp and nop this is linear
+func FindRepair(program []Instruction) (Repair, bool) {
+	path, state := ExecutionPath(program)
+	if state == Terminated {
+		return Repair{}, false
+	}
+	indices := repairsAfter(program, path, ReachesEnd(program), true)
+	if len(indices) == 0 {
+		return Repair{}, false
+	}
+	accumulator, _ := Execute(flipped(program, indices[0]))
+	return Repair{[]int{LineOf(program, indices[0])}, accumulator}, true
+}
+
+// SingleRepairs lists every single flip that makes a looping program terminate
+func SingleRepairs(program []Instruction) []Repair {
+	path, state := ExecutionPath(program)
+	if state == Terminated {
+		return nil
+	}
+	var repairs []Repair
+	for _, index := range repairsAfter(program, path, ReachesEnd(program), false) {
+		accumulator, _ := Execute(flipped(program, index))
+		repairs = append(repairs, Repair{[]int{LineOf(program, index)}, accumulator})
+	}
+	return repairs
+}
+
+// DoubleRepairs lists every pair of flips that makes a looping program terminate when neither flip does alone
+// For each first flip along the execution path, the flipped program gets its own analysis to find the second, so this is quadratic
+func DoubleRepairs(program []Instruction) []Repair {
+	path, state := ExecutionPath(program)
+	if state == Terminated {
+		return nil
+	}
+	single := make(map[int]bool)
+	for _, index := range repairsAfter(program, path, ReachesEnd(program), false) {
+		single[index] = true
+	}
+
+	// Whichever flip the program reaches first is taken as the first, so every pair is found exactly once
+	var repairs []Repair
+	seen := make(map[int]bool)
+	for _, first := range path {
+		seen[first] = true
+		if single[first] {
+			continue
+		}
+		once := flipped(program, first)
+		if once[first].Op == program[first].Op {
+			continue
+		}
+		oncePath, _ := ExecutionPath(once)
+		var after []int
+		for _, index := range oncePath {
+			if !seen[index] {
+				after = append(after, index)
+			}
+		}
+		for _, second := range repairsAfter(once, after, ReachesEnd(once), false) {
+			if single[second] {
+				continue
+			}
+			accumulator, terminated := Execute(flipped(program, first, second))
+			if terminated {
+				repairs = append(repairs, Repair{[]int{LineOf(program, first), LineOf(program, second)}, accumulator})
+			}
+		}
+	}
+	return repairs
+}
+
+// BasicBlock is a run of instructions that always execute together, from Start up to but not including End
+// Next is the index the block hands over to, len(program) if it terminates and -1 if it halts any other way
+type BasicBlock struct {
+	Start int
+	End   int
+	Next  int
+}
+
+// BasicBlocks splits the program wherever a jump lands or leaves, and after any operation that isn't understood
+func BasicBlocks(program []Instruction) []BasicBlock {
+	leaders := make([]bool, len(program)+1)
+	leaders[0] = true
+	for index, instruction := range program {
+		next, ok := target(instruction, index)
+		jump := Opcodes[instruction.Op].Jump
+		if jump && ok && next >= 0 && next < len(program) {
+			leaders[next] = true
+		}
+		if jump || !ok {
+			leaders[index+1] = true
+		}
+	}
+	var blocks []BasicBlock
+	for start := 0; start < len(program); {
+		end := start + 1
+		for end < len(program) && !leaders[end] {
+			end++
+		}
+		blocks = append(blocks, BasicBlock{start, end, Successor(program, end-1)})
+		start = end
+	}
+	return blocks
+}
+
+// Analysis is everything that can be said about a program without running it
+// Nothing the program computes affects where it jumps, so the main execution path is also exactly the set of reachable instructions
+type Analysis struct {
+	Program     []Instruction
+	Blocks      []BasicBlock
+	BlockOf     []int
+	MainPath    []int
+	Halt        HaltState
+	OnPath      []bool
+	Unreachable []int
+	OutOfBounds []int
+	Invalid     []int
+	Loops       [][]int
+	LoopOf      []int
+}
+
+// Analyze follows the control-flow graph of the program, finding its blocks, main path and every loop
+func Analyze(program []Instruction) *Analysis {
+	analysis := &Analysis{
+		Program: program,
+		Blocks:  BasicBlocks(program),
+		BlockOf: make([]int, len(program)),
+		OnPath:  make([]bool, len(program)),
+		LoopOf:  make([]int, len(program)),
+	}
+	for number, block := range analysis.Blocks {
+		for index := block.Start; index < block.End; index++ {
+			analysis.BlockOf[index] = number
 		}
-		if strings.HasPrefix(valStr, "-") {
-			val *= -1
+	}
+	for index, instruction := range program {
+		analysis.LoopOf[index] = -1
+		next, ok := target(instruction, index)
+		if !ok {
+			analysis.Invalid = append(analysis.Invalid, index)
+		} else if next < 0 || next > len(program) {
+			analysis.OutOfBounds = append(analysis.OutOfBounds, index)
 		}
+	}
 
-		// Break if the command has been executed before; otherwise, execute the command
-		if _, before := executed[current]; before {
+	// The main path is a walk from the first instruction, which ends when it leaves the program or comes back on itself
+	analysis.Halt = Terminated
+	for index := 0; index < len(program); {
+		if analysis.OnPath[index] {
+			analysis.Halt = LoopDetected
 			break
 		}
-		executed[current] = struct{}{}
-		if cmd == "acc" {
-			accumulator += val
-			current++
-		} else if cmd == "nop" {
-			current++
-		} else if cmd == "jmp" {
-			current += val
+		analysis.OnPath[index] = true
+		analysis.MainPath = append(analysis.MainPath, index)
+		next := Successor(program, index)
+		if next < 0 {
+			analysis.Halt = OutOfBounds
+			if containsIndex(analysis.Invalid, index) {
+				analysis.Halt = InvalidOpcode
+			}
+			break
+		}
+		index = next
+	}
+	for index := range program {
+		if !analysis.OnPath[index] {
+			analysis.Unreachable = append(analysis.Unreachable, index)
 		}
 	}
 
-	return accumulator, completed
+	// Every instruction has at most one successor, so walking on from each instruction either halts or ends in a loop
+	// A walk that comes back to an instruction it visited itself has found a new loop, and any other walk stops at the first instruction already seen
+	walked := make([]int, len(program))
+	for start := range program {
+		var walk []int
+		for index := start; index >= 0 && index < len(program) && walked[index] == 0; index = Successor(program, index) {
+			walked[index] = start + 1
+			walk = append(walk, index)
+		}
+		if len(walk) == 0 {
+			continue
+		}
+		last := walk[len(walk)-1]
+		if next := Successor(program, last); next >= 0 && next < len(program) && walked[next] == start+1 {
+			var loop []int
+			for i := len(walk) - 1; walk[i] != next; i-- {
+				loop = append([]int{walk[i]}, loop...)
+			}
+			loop = append([]int{next}, loop...)
+			for _, index := range loop {
+				analysis.LoopOf[index] = len(analysis.Loops)
+			}
+			analysis.Loops = append(analysis.Loops, loop)
+		}
+	}
+	return analysis
+}
+
+// lineRanges writes indices into the program as lines of the boot code, joining runs of consecutive lines, e.g. "4-6, 2"
+func lineRanges(program []Instruction, indices []int) string {
+	var ranges []string
+	for i := 0; i < len(indices); {
+		j := i
+		for j+1 < len(indices) && LineOf(program, indices[j+1]) == LineOf(program, indices[j])+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(LineOf(program, indices[i])))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", LineOf(program, indices[i]), LineOf(program, indices[j])))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// describeNext says where a block or instruction hands over to
+func (analysis *Analysis) describeNext(next, last int) string {
+	switch {
+	case next == len(analysis.Program):
+		return "end"
+	case next >= 0:
+		return fmt.Sprintf("block %d", analysis.BlockOf[next]+1)
+	case containsIndex(analysis.Invalid, last):
+		return "invalid opcode"
+	}
+	return "out of bounds"
+}
+
+func containsIndex(indices []int, index int) bool {
+	for _, i := range indices {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes the analysis as a listing of every block and instruction, followed by a summary of the problems found
+// Each instruction is marked * on the main path, U if unreachable, L if part of a loop and ! if it leaves the program or isn't understood
+func (analysis *Analysis) WriteText(writer io.Writer) error {
+	program := analysis.Program
+	lines := []string{
+		fmt.Sprintf("%d instructions in %d basic blocks", len(program), len(analysis.Blocks)),
+		fmt.Sprintf("Main path: %d instructions, then %s", len(analysis.MainPath), analysis.Halt),
+		"",
+	}
+	for number, block := range analysis.Blocks {
+		var indices []int
+		for index := block.Start; index < block.End; index++ {
+			indices = append(indices, index)
+		}
+		noun := "lines"
+		if len(indices) == 1 {
+			noun = "line"
+		}
+		lines = append(lines, fmt.Sprintf("block %d: %s %s -> %s", number+1, noun, lineRanges(analysis.Program, indices), analysis.describeNext(block.Next, block.End-1)))
+		for index := block.Start; index < block.End; index++ {
+			marks := []byte("    ")
+			if analysis.OnPath[index] {
+				marks[0] = '*'
+			} else {
+				marks[1] = 'U'
+			}
+			if analysis.LoopOf[index] >= 0 {
+				marks[2] = 'L'
+			}
+			if containsIndex(analysis.OutOfBounds, index) || containsIndex(analysis.Invalid, index) {
+				marks[3] = '!'
+			}
+			lines = append(lines, fmt.Sprintf("  %s %4d  %s", marks, LineOf(program, index), program[index]))
+		}
+	}
+
+	lines = append(lines, "", fmt.Sprintf("Main path: %s", lineRanges(analysis.Program, analysis.MainPath)))
+	lines = append(lines, fmt.Sprintf("Unreachable: %d instructions", len(analysis.Unreachable)))
+	if len(analysis.Unreachable) > 0 {
+		lines = append(lines, "  lines "+lineRanges(analysis.Program, analysis.Unreachable))
+	}
+	lines = append(lines, fmt.Sprintf("Jumps outside the program: %d", len(analysis.OutOfBounds)))
+	for _, index := range analysis.OutOfBounds {
+		next, _ := target(program[index], index)
+		lines = append(lines, fmt.Sprintf("  line %d: %s goes to line %d", LineOf(program, index), program[index], LineOf(program, next)))
+	}
+	if len(analysis.Invalid) > 0 {
+		lines = append(lines, fmt.Sprintf("Invalid opcodes: lines %s", lineRanges(analysis.Program, analysis.Invalid)))
+	}
+	lines = append(lines, fmt.Sprintf("Infinite loops: %d", len(analysis.Loops)))
+	for number, loop := range analysis.Loops {
+		entered := ""
+		if analysis.OnPath[loop[0]] {
+			entered = ", on the main path"
+		}
+		lines = append(lines, fmt.Sprintf("  loop %d: %d instructions%s, lines %s", number+1, len(loop), entered, lineRanges(analysis.Program, loop)))
+	}
+	_, err := io.WriteString(writer, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// WriteDOT writes the control-flow graph as a Graphviz digraph with a node for every basic block
+// The main path is drawn in red, loops are filled in pink and unreachable blocks are dashed
+func (analysis *Analysis) WriteDOT(writer io.Writer) error {
+	lines := []string{"digraph program {", "\tnode [shape=box, fontname=monospace];"}
+	for number, block := range analysis.Blocks {
+		label := fmt.Sprintf("block %d\\l", number+1)
+		for index := block.Start; index < block.End; index++ {
+			label += fmt.Sprintf("%4d  %s\\l", LineOf(analysis.Program, index), analysis.Program[index])
+		}
+		var styles []string
+		attributes := fmt.Sprintf("label=\"%s\"", label)
+		if analysis.LoopOf[block.Start] >= 0 {
+			styles = append(styles, "filled")
+			attributes += ", fillcolor=pink"
+		}
+		if !analysis.OnPath[block.Start] {
+			styles = append(styles, "dashed")
+			attributes += ", fontcolor=grey"
+		}
+		if len(styles) > 0 {
+			attributes += fmt.Sprintf(", style=\"%s\"", strings.Join(styles, ","))
+		}
+		lines = append(lines, fmt.Sprintf("\tb%d [%s];", number+1, attributes))
+	}
+	lines = append(lines, "\tend [shape=doublecircle];")
+	if len(analysis.OutOfBounds) > 0 {
+		lines = append(lines, "\toutside [label=\"out of bounds\", shape=octagon, color=red];")
+	}
+	if len(analysis.Invalid) > 0 {
+		lines = append(lines, "\tinvalid [label=\"invalid opcode\", shape=octagon, color=red];")
+	}
+	lines = append(lines, "\tstart [shape=point];", "\tstart -> b1 [color=red, penwidth=2];")
+	for number, block := range analysis.Blocks {
+		head := "outside"
+		switch {
+		case block.Next == len(analysis.Program):
+			head = "end"
+		case block.Next >= 0:
+			head = fmt.Sprintf("b%d", analysis.BlockOf[block.Next]+1)
+		case containsIndex(analysis.Invalid, block.End-1):
+			head = "invalid"
+		}
+		attributes := ""
+		if analysis.OnPath[block.Start] {
+			attributes = " [color=red, penwidth=2]"
+		}
+		lines = append(lines, fmt.Sprintf("\tb%d -> %s%s;", number+1, head, attributes))
+	}
+	lines = append(lines, "}")
+	_, err := io.WriteString(writer, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// LoopInfo describes the loop a program falls into, as seen by running it until the first repeated instruction
+// Lead is the path taken before the loop is entered and Cycle is one trip around it, starting at Entry
+// EntryAccumulator is the accumulator on first reaching Entry, and Delta is how much each trip around the loop adds to it
+type LoopInfo struct {
+	Entry            int
+	Lead             []TraceEntry
+	Cycle            []TraceEntry
+	Period           int
+	EntryAccumulator int
+	Delta            int
+}
+
+// FindLoop runs the program until it repeats an instruction, reporting false if it halts any other way
+func FindLoop(program []Instruction) (LoopInfo, bool) {
+	machine := NewMachine(program)
+	var trace []TraceEntry
+	for {
+		entry, executed := TraceStep(machine)
+		if !executed {
+			break
+		}
+		trace = append(trace, entry)
+	}
+	if machine.State != LoopDetected {
+		return LoopInfo{}, false
+	}
+
+	// The instruction the machine refused to repeat is where the loop starts
+	loop := LoopInfo{Entry: machine.PC}
+	for i, entry := range trace {
+		if entry.Index == loop.Entry {
+			loop.Lead, loop.Cycle = trace[:i], trace[i:]
+			break
+		}
+	}
+	loop.Period = len(loop.Cycle)
+	loop.EntryAccumulator = loop.Cycle[0].Before
+	loop.Delta = machine.Accumulator - loop.EntryAccumulator
+	return loop, true
+}
+
+// AccumulatorAfter predicts the accumulator once the program has been around the loop the given number of times
+// Nothing the program computes affects where it jumps, so every trip around the loop adds the same amount
+func (loop LoopInfo) AccumulatorAfter(cycles int) int {
+	return loop.EntryAccumulator + cycles*loop.Delta
+}
+
+// AccumulatorAtStep predicts the accumulator once the program has executed the given number of instructions
+func (loop LoopInfo) AccumulatorAtStep(steps int) int {
+	if steps <= 0 {
+		return 0
+	}
+	if steps <= len(loop.Lead) {
+		return loop.Lead[steps-1].After
+	}
+	steps -= len(loop.Lead)
+	cycles, remainder := steps/loop.Period, steps%loop.Period
+	if remainder == 0 {
+		return loop.AccumulatorAfter(cycles)
+	}
+	return loop.AccumulatorAfter(cycles) + loop.Cycle[remainder-1].After - loop.EntryAccumulator
+}
+
+// WriteText writes the loop's structure, followed by every instruction in one trip around it
+func (loop LoopInfo) WriteText(writer io.Writer) error {
+	lines := []string{
+		fmt.Sprintf("Entry: line %d, after %d instructions", loop.Cycle[0].Line, len(loop.Lead)),
+		fmt.Sprintf("Period: %d instructions", loop.Period),
+		fmt.Sprintf("Accumulator on entry: %d", loop.EntryAccumulator),
+		fmt.Sprintf("Accumulator change per cycle: %+d", loop.Delta),
+		"Cycle:",
+	}
+	for _, entry := range loop.Cycle {
+		lines = append(lines, entry.String())
+	}
+	_, err := io.WriteString(writer, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// reLabel matches the names the assembler accepts for labels
+var reLabel = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// assemblyLine is an instruction waiting for its label to be resolved
+type assemblyLine struct {
+	line int
+	op   string
+	arg  string
+}
+
+// Assemble reads assembly into a program, resolving labels into the relative offsets the console uses
+// Comments start with # or ; and run to the end of the line
+// A label such as "loop:" names the instruction after it, either on the same line or the next, or the end of the program if there is none
+// A jump, or a nop that a repair could flip into one, can take a label instead of an offset, so "jmp loop" jumps to the loop label, and any operation can take a signed number
+func Assemble(reader io.Reader) ([]Instruction, error) {
+	labels := make(map[string]int)
+	labelLines := make(map[string]int)
+	var pending []assemblyLine
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := scanner.Text()
+		if comment := strings.IndexAny(text, "#;"); comment >= 0 {
+			text = text[:comment]
+		}
+		text = strings.TrimSpace(text)
+		for colon := strings.Index(text, ":"); colon >= 0; colon = strings.Index(text, ":") {
+			label := strings.TrimSpace(text[:colon])
+			if !reLabel.MatchString(label) {
+				return nil, fmt.Errorf("line %d: %q is not a label name", lineNumber, label)
+			}
+			if line, ok := labelLines[label]; ok {
+				return nil, fmt.Errorf("line %d: label %s is already defined on line %d", lineNumber, label, line)
+			}
+			labels[label] = len(pending)
+			labelLines[label] = lineNumber
+			text = strings.TrimSpace(text[colon+1:])
+		}
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: instruction %q should be an operation and an argument", lineNumber, text)
+		}
+		pending = append(pending, assemblyLine{lineNumber, fields[0], fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Every label is known by now, so they can be turned into offsets
+	program := make([]Instruction, len(pending))
+	for index, line := range pending {
+		if strings.ContainsRune("+-0123456789", rune(line.arg[0])) {
+			instruction, err := ParseInstruction(line.op + " " + line.arg)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.line, err)
+			}
+			program[index] = instruction
+			continue
+		}
+		flippedOp := Instruction{Op: line.op}
+		flip(&flippedOp)
+		if !Opcodes[line.op].Jump && !Opcodes[flippedOp.Op].Jump {
+			return nil, fmt.Errorf("line %d: %s takes a signed number, not a label", line.line, line.op)
+		}
+		target, ok := labels[line.arg]
+		if !ok {
+			return nil, fmt.Errorf("line %d: label %s is not defined", line.line, line.arg)
+		}
+		program[index] = Instruction{Op: line.op, Arg: target - index}
+	}
+	return program, nil
+}
+
+// Disassemble writes the program as assembly, with a label at every jump target in place of its offset
+// Labels are named after the line they mark, e.g. line144, and the end of the program is labelled end
+// Jumps outside the program and every other argument keep their numbers
+// The assembly is assembled again before it is written, to make sure it gives back exactly the same program
+func Disassemble(program []Instruction, writer io.Writer) error {
+	labelName := func(index int) string {
+		if index == len(program) {
+			return "end"
+		}
+		return "line" + strconv.Itoa(LineOf(program, index))
+	}
+	labelled := make([]bool, len(program)+1)
+	for index, instruction := range program {
+		if next, ok := target(instruction, index); ok && Opcodes[instruction.Op].Jump && next >= 0 && next <= len(program) {
+			labelled[next] = true
+		}
+	}
+
+	var lines []string
+	for index, instruction := range program {
+		if labelled[index] {
+			lines = append(lines, labelName(index)+":")
+		}
+		argument := fmt.Sprintf("%+d", instruction.Arg)
+		if next, ok := target(instruction, index); ok && Opcodes[instruction.Op].Jump && next >= 0 && next <= len(program) {
+			argument = labelName(next)
+		}
+		lines = append(lines, "\t"+instruction.Op+" "+argument)
+	}
+	if labelled[len(program)] {
+		lines = append(lines, labelName(len(program))+":")
+	}
+	assembly := strings.Join(lines, "\n") + "\n"
+
+	reassembled, err := Assemble(strings.NewReader(assembly))
+	if err != nil {
+		return fmt.Errorf("disassembly does not assemble: %w", err)
+	}
+	if len(reassembled) != len(program) {
+		return fmt.Errorf("disassembly assembles to %d instructions, not %d", len(reassembled), len(program))
+	}
+	for index := range program {
+		if reassembled[index].Op != program[index].Op || reassembled[index].Arg != program[index].Arg {
+			return fmt.Errorf("disassembly assembles line %d to %s, not %s", LineOf(program, index), reassembled[index], program[index])
+		}
+	}
+	_, err = io.WriteString(writer, assembly)
+	return err
 }
 
 func main() {
+	trace := flag.Bool("trace", false, "write every instruction the unmodified program executes to stdout instead of solving the puzzle")
+	debug := flag.Bool("debug", false, "debug the unmodified program with commands read from stdin instead of solving the puzzle")
+	history := flag.Int("history", 1000, "how many executed instructions the debugger keeps for backtraces")
+	analyze := flag.Bool("analyze", false, "write a static analysis of the unmodified program to stdout instead of solving the puzzle")
+	cfg := flag.Bool("cfg", false, "write the control-flow graph of the unmodified program to stdout as Graphviz DOT instead of solving the puzzle")
+	assemble := flag.String("assemble", "", "assemble this file and write the program to stdout instead of solving the puzzle")
+	disassemble := flag.Bool("disassemble", false, "write the unmodified program to stdout as assembly with labels instead of solving the puzzle")
+	loopReport := flag.Bool("loop", false, "describe the loop the unmodified program falls into instead of solving the puzzle")
+	cycles := flag.Int("cycles", -1, "with -loop, predict the accumulator after this many trips around the loop")
+	steps := flag.Int("steps", -1, "with -loop, predict the accumulator after this many executed instructions")
+	repairs := flag.String("repairs", "", "list every repair of the program instead of solving the puzzle: single or double flips")
+	flag.Parse()
+
+	// The assembler reads its own file rather than the puzzle input
+	if *assemble != "" {
+		source, err := os.Open(*assemble)
+		if err != nil {
+			log.Fatal(err)
+		}
+		program, err := Assemble(source)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, instruction := range program {
+			fmt.Println(instruction)
+		}
+		return
+	}
+
 	// Reader
 	path := "./input.txt"
 	buf, err := os.Open(path)
 	if err != nil {
 		log.Fatal(err)
 	}
-	scanner := bufio.NewScanner(buf)
 
 	// Retrieve input
-	input := make(map[int]string)
-	lineNumber := 1
-	for scanner.Scan() {
-		input[lineNumber] = scanner.Text()
-		lineNumber++
+	program, err := ParseProgram(buf)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// P1: Trace a period of the infinite loop by storing a simple map - keys are line numbers, values are empty structs.
-	// If checking the map for the given key does not return nil, then the line number was already in. At that point, stop execution of the game code.
-	// At this point, return the value in the accumulator.
-	finalValue, success := Execute(input)
-	log.Println("P1 | Accumulator:", finalValue, "| Completed successfully:", success)
-
-	// P2: There's no guarantee that the last jmp or nop is the one that needs to be fixed.
-	// Instead, at every discovered jmp or nop, attempt flipping it to the opposite instruction in mem (the line number itself doesn't need to be returned)
-	// If it doesn't work, then just proceed as normal until another jmp or nop is encountered
-	// According to the puzzle, one of these is guaranteed to succeed
-	for i := 1; i < len(input); i++ {
-		if strings.HasPrefix(input[i], "jmp") {
-			input[i] = "nop " + strings.Split(input[i], " ")[1]
-		} else if strings.HasPrefix(input[i], "nop") {
-			input[i] = "jmp " + strings.Split(input[i], " ")[1]
-		} else {
-			continue
+	// -trace, -debug, -disassemble, -loop, -analyze, -cfg and -repairs each write their own output instead of the P1 and P2 answers
+	if *trace {
+		if _, _, err := ExecuteTrace(program, os.Stdout); err != nil {
+			log.Fatal(err)
 		}
-		finalValue, success = Execute(input)
-		if success {
-			log.Println("P2 | Modified line:", i, "| Accumulator:", finalValue, "| Completed successfully:", success)
-			break
+		return
+	}
+	if *debug {
+		if *history < 0 {
+			log.Fatal("History should be at least 0, it was ", *history)
+		}
+		if err := RunDebugger(NewDebugger(program, *history), os.Stdin, os.Stdout, true); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *disassemble {
+		if err := Disassemble(program, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *loopReport {
+		loop, found := FindLoop(program)
+		if !found {
+			log.Fatal("The program does not loop")
+		}
+		if err := loop.WriteText(os.Stdout); err != nil {
+			log.Fatal(err)
 		}
-		if strings.HasPrefix(input[i], "jmp") {
-			input[i] = "nop " + strings.Split(input[i], " ")[1]
-		} else if strings.HasPrefix(input[i], "nop") {
-			input[i] = "jmp " + strings.Split(input[i], " ")[1]
+		if *cycles >= 0 {
+			fmt.Printf("Accumulator after %d cycles: %d\n", *cycles, loop.AccumulatorAfter(*cycles))
 		}
+		if *steps >= 0 {
+			fmt.Printf("Accumulator after %d steps: %d\n", *steps, loop.AccumulatorAtStep(*steps))
+		}
+		return
+	}
+	if *analyze {
+		if err := Analyze(program).WriteText(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *cfg {
+		if err := Analyze(program).WriteDOT(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *repairs != "" {
+		var found []Repair
+		switch *repairs {
+		case "single":
+			found = SingleRepairs(program)
+		case "double":
+			found = DoubleRepairs(program)
+		default:
+			log.Fatal("repairs should be single or double, it was ", *repairs)
+		}
+		fmt.Printf("%d %s repairs\n", len(found), *repairs)
+		for _, repair := range found {
+			fmt.Println(repair)
+		}
+		return
+	}
+
+	// P1: Trace a period of the infinite loop - the machine remembers which instructions it has executed.
+	// If the upcoming instruction was already executed, the machine halts before executing it again.
+	// At this point, return the value in the accumulator.
+	machine := NewMachine(program)
+	state := machine.Run()
+	log.Println("P1 | Accumulator:", machine.Accumulator, "| Completed successfully:", state == Terminated, "| Halted:", state)
+
+	// P2: There's no guarantee that the last jmp or nop is the one that needs to be fixed.
+	// Rather than flipping each one and rerunning the program, work backwards from the end to find every instruction that terminates.
+	// Then the broken instruction is the one on the looping path whose flipped version jumps into that set.
+	// According to the puzzle, exactly one of these exists
+	repair, found := FindRepair(program)
+	if !found {
+		log.Fatal("P2 | No single flipped instruction makes the program terminate")
 	}
+	log.Println("P2 | Modified line:", repair.Lines[0], "| Accumulator:", repair.Accumulator, "| Completed successfully:", found)
 }